@@ -0,0 +1,126 @@
+package cubeapi
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Decoder reads frames and their fields directly from io.Reader. Unlike
+// RespBuffer it needs no separate go-routine: every Read call blocks until
+// data is read or reader fails
+type Decoder struct {
+	r       io.Reader
+	offset  int64
+	scratch [int64Len]byte
+}
+
+// CreateDecoder creates Decoder
+func CreateDecoder(r io.Reader) *Decoder {
+	return &Decoder{
+		r: r,
+	}
+}
+
+// Offset returns offset of next field from start of frame
+func (d *Decoder) Offset() int64 {
+	return d.offset
+}
+
+// SetOffset sets offset of next field, e.g. HeaderLen for decoder of body
+// read separately
+func (d *Decoder) SetOffset(offset int64) {
+	d.offset = offset
+}
+
+func (d *Decoder) read(p []byte, op string) error {
+	return d.readField(p, op, d.offset)
+}
+
+// readField reads part of field starting at offset
+func (d *Decoder) readField(p []byte, op string, offset int64) error {
+	n, err := io.ReadFull(d.r, p)
+	d.offset += int64(n)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		e := wrapError(op, offset, ErrNotEnoughData)
+		e.Expected, e.Available = int64(len(p)), int64(n)
+		return e
+	}
+	if err != nil {
+		return wrapError(op, offset, err)
+	}
+	return nil
+}
+
+// ReadHeader reads header, it starts new frame
+func (d *Decoder) ReadHeader(h *Header) error {
+	d.offset = 0
+	buf := make([]byte, HeaderLen)
+	if err := d.read(buf, "read header"); err != nil {
+		return err
+	}
+	h.SvcID = int32(binary.LittleEndian.Uint32(buf[0:4]))
+	h.BodyLength = int32(binary.LittleEndian.Uint32(buf[4:8]))
+	h.RequestID = int32(binary.LittleEndian.Uint32(buf[8:12]))
+	return nil
+}
+
+// ReadInt32 reads int32
+func (d *Decoder) ReadInt32(i *int32) error {
+	if err := d.read(d.scratch[:int32Len], "read int32"); err != nil {
+		return err
+	}
+	*i = int32(binary.LittleEndian.Uint32(d.scratch[:int32Len]))
+	return nil
+}
+
+// ReadInt64 reads int64
+func (d *Decoder) ReadInt64(i *int64) error {
+	if err := d.read(d.scratch[:int64Len], "read int64"); err != nil {
+		return err
+	}
+	*i = int64(binary.LittleEndian.Uint64(d.scratch[:int64Len]))
+	return nil
+}
+
+// ReadString reads string
+func (d *Decoder) ReadString(s *string) error {
+	offset := d.offset
+	if err := d.read(d.scratch[:int32Len], "read string"); err != nil {
+		return err
+	}
+	strLen := int32(binary.LittleEndian.Uint32(d.scratch[:int32Len]))
+	if strLen < 0 {
+		return wrapError("read string", offset, fmt.Errorf("negative length %d: %w", strLen, ErrIncorrectData))
+	}
+	if strLen > MaxBodyLen {
+		return wrapError("read string", offset, fmt.Errorf("length %d is too big: %w", strLen, ErrIncorrectLen))
+	}
+	str := make([]byte, strLen)
+	if err := d.readField(str, "read string", offset); err != nil {
+		return err
+	}
+	*s = string(str)
+	return nil
+}
+
+// ReadFrame reads header and returns body of BodyLength bytes
+func (d *Decoder) ReadFrame(h *Header) ([]byte, error) {
+	if err := d.ReadHeader(h); err != nil {
+		return nil, err
+	}
+	return d.ReadBody(h)
+}
+
+// ReadBody returns body of BodyLength bytes following already read header
+func (d *Decoder) ReadBody(h *Header) ([]byte, error) {
+	if h.BodyLength < 0 || h.BodyLength > MaxBodyLen {
+		return nil, wrapError("read body", d.offset,
+			fmt.Errorf("body length %d: %w", h.BodyLength, ErrIncorrectBodyLen))
+	}
+	body := make([]byte, h.BodyLength)
+	if err := d.read(body, "read body"); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
@@ -0,0 +1,101 @@
+package cubeapi_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/Apakhov/cube/cubeapi"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadHeader(t *testing.T) {
+	testBytes := []byte{0x4, 0, 0, 0, 0x16, 0, 0, 0, 0x3, 0, 0, 0}
+
+	exp := &cubeapi.Header{
+		SvcID:      0x4,
+		BodyLength: 0x16,
+		RequestID:  0x3,
+	}
+	res := &cubeapi.Header{}
+	err := cubeapi.CreateDecoder(bytes.NewReader(testBytes)).ReadHeader(res)
+	require.NoError(t, err)
+	if !reflect.DeepEqual(exp, res) {
+		require.Equal(t, exp, res, "result difference")
+	}
+}
+
+func TestReadHeaderErr(t *testing.T) {
+	testBytess := [][]byte{
+		{0x4, 0, 0, 0, 0x16, 0, 0, 0, 0x3, 0, 0},
+		{0x4, 0, 0, 0, 0x16, 0, 0, 0},
+		{},
+	}
+	for i, testBytes := range testBytess {
+		err := cubeapi.CreateDecoder(bytes.NewReader(testBytes)).ReadHeader(&cubeapi.Header{})
+		require.True(t, errors.Is(err, cubeapi.ErrNotEnoughData), fmt.Sprintf("%d expected error", i))
+	}
+}
+
+func TestReadFields(t *testing.T) {
+	testBytes := append([]byte{42, 0, 0, 0, 43, 0, 0, 0, 0, 0, 0, 0}, buildString("string")...)
+	d := cubeapi.CreateDecoder(bytes.NewReader(testBytes))
+
+	var i32 int32
+	var i64 int64
+	var str string
+	require.NoError(t, d.ReadInt32(&i32))
+	require.NoError(t, d.ReadInt64(&i64))
+	require.NoError(t, d.ReadString(&str))
+	require.Equal(t, int32(42), i32)
+	require.Equal(t, int64(43), i64)
+	require.Equal(t, "string", str)
+	require.True(t, errors.Is(d.ReadInt32(&i32), cubeapi.ErrNotEnoughData))
+}
+
+func TestReadStringErr(t *testing.T) {
+	testCases := []struct {
+		str []byte
+		err error
+	}{
+		{[]byte{1, 1}, cubeapi.ErrNotEnoughData},
+		{[]byte{0x4, 0, 0, 0}, cubeapi.ErrNotEnoughData},
+		{[]byte{0x00, 0x00, 0x00, 0x40}, cubeapi.ErrIncorrectLen},
+		{[]byte{0x00, 0x00, 0x00, 0xF0}, cubeapi.ErrIncorrectData},
+	}
+	for i, testCase := range testCases {
+		var res string
+		err := cubeapi.CreateDecoder(bytes.NewReader(testCase.str)).ReadString(&res)
+		require.True(t, errors.Is(err, testCase.err), fmt.Sprintf("%d expected error", i))
+	}
+}
+
+func TestDecoderReadFrame(t *testing.T) {
+	d := cubeapi.CreateDecoder(bytes.NewReader(buildFrame(3, 42)))
+	h := &cubeapi.Header{}
+	body, err := d.ReadFrame(h)
+	require.NoError(t, err)
+	require.Equal(t, cubeapi.Header{SvcID: 1, BodyLength: 4, RequestID: 3}, *h)
+	require.Equal(t, []byte{42, 0, 0, 0}, body)
+
+	_, err = d.ReadFrame(h)
+	require.True(t, errors.Is(err, cubeapi.ErrNotEnoughData))
+}
+
+func TestDecoderReaderErr(t *testing.T) {
+	readErr := errors.New("read failed")
+	d := cubeapi.CreateDecoder(io.MultiReader(bytes.NewReader([]byte{1, 0}), &failingReader{readErr}))
+	var res int32
+	require.True(t, errors.Is(d.ReadInt32(&res), readErr))
+}
+
+type failingReader struct {
+	err error
+}
+
+func (r *failingReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
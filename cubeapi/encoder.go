@@ -0,0 +1,155 @@
+package cubeapi
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Field is typed field of frame body
+type Field interface {
+	// Len returns length of encoded field
+	Len() int64
+	encode(e *Encoder) error
+}
+
+// Int32 is int32 field
+type Int32 int32
+
+// Len implements Field interface
+func (i Int32) Len() int64 {
+	return int32Len
+}
+
+func (i Int32) encode(e *Encoder) error {
+	return e.WriteInt32(int32(i))
+}
+
+// Int64 is int64 field
+type Int64 int64
+
+// Len implements Field interface
+func (i Int64) Len() int64 {
+	return int64Len
+}
+
+func (i Int64) encode(e *Encoder) error {
+	return e.WriteInt64(int64(i))
+}
+
+// String is string field, encoded as length and bytes
+type String string
+
+// Len implements Field interface
+func (s String) Len() int64 {
+	return int32Len + int64(len(s))
+}
+
+func (s String) encode(e *Encoder) error {
+	return e.WriteString(string(s))
+}
+
+// BodyLen computes length of body consisting of fields
+func BodyLen(fields ...Field) (int32, error) {
+	l := int64(HeaderLen)
+	for _, f := range fields {
+		if s, ok := f.(String); ok && len(s) > math.MaxInt32 {
+			return 0, wrapError("compute body length", l, ErrStringTooLong)
+		}
+		l += f.Len()
+	}
+	l -= HeaderLen
+	if l > math.MaxInt32 {
+		return 0, wrapError("compute body length", -1, fmt.Errorf("body length %d: %w", l, ErrIncorrectBodyLen))
+	}
+	return int32(l), nil
+}
+
+// Encoder writes frames and their fields directly to io.Writer. Unlike
+// SendBuffer it doesn't accumulate request in memory, so writer should be
+// buffered if many small writes are expensive
+type Encoder struct {
+	w       io.Writer
+	offset  int64
+	scratch [HeaderLen]byte
+}
+
+// CreateEncoder creates Encoder
+func CreateEncoder(w io.Writer) *Encoder {
+	return &Encoder{
+		w: w,
+	}
+}
+
+func (e *Encoder) write(p []byte, op string) error {
+	offset := e.offset
+	n, err := e.w.Write(p)
+	e.offset += int64(n)
+	if err != nil {
+		return wrapError(op, offset, err)
+	}
+	return nil
+}
+
+// WriteHeader writes header, it starts new frame
+func (e *Encoder) WriteHeader(h Header) error {
+	e.offset = 0
+	binary.LittleEndian.PutUint32(e.scratch[0:4], uint32(h.SvcID))
+	binary.LittleEndian.PutUint32(e.scratch[4:8], uint32(h.BodyLength))
+	binary.LittleEndian.PutUint32(e.scratch[8:12], uint32(h.RequestID))
+	return e.write(e.scratch[:HeaderLen], "write header")
+}
+
+// WriteInt32 writes int32
+func (e *Encoder) WriteInt32(i int32) error {
+	binary.LittleEndian.PutUint32(e.scratch[:int32Len], uint32(i))
+	return e.write(e.scratch[:int32Len], "write int32")
+}
+
+// WriteInt64 writes int64
+func (e *Encoder) WriteInt64(i int64) error {
+	binary.LittleEndian.PutUint64(e.scratch[:int64Len], uint64(i))
+	return e.write(e.scratch[:int64Len], "write int64")
+}
+
+// WriteString writes string
+func (e *Encoder) WriteString(s string) error {
+	offset := e.offset
+	if len(s) > math.MaxInt32 {
+		return wrapError("write string", offset, ErrStringTooLong)
+	}
+	binary.LittleEndian.PutUint32(e.scratch[:int32Len], uint32(len(s)))
+	if err := e.write(e.scratch[:int32Len], "write string"); err != nil {
+		return err
+	}
+	n, err := io.WriteString(e.w, s)
+	e.offset += int64(n)
+	if err != nil {
+		return wrapError("write string", offset, err)
+	}
+	return nil
+}
+
+// WriteFrame writes header with body length computed from fields and fields
+// themselves
+func (e *Encoder) WriteFrame(svcID, requestID int32, fields ...Field) error {
+	bodyLen, err := BodyLen(fields...)
+	if err != nil {
+		return err
+	}
+	err = e.WriteHeader(Header{
+		SvcID:      svcID,
+		BodyLength: bodyLen,
+		RequestID:  requestID,
+	})
+	if err != nil {
+		return err
+	}
+	for _, f := range fields {
+		if err = f.encode(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
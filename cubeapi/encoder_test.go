@@ -0,0 +1,65 @@
+package cubeapi_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/Apakhov/cube/cubeapi"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteFrame(t *testing.T) {
+	w := &bytes.Buffer{}
+	err := cubeapi.CreateEncoder(w).WriteFrame(0x1, 0x3,
+		cubeapi.Int32(0x42),
+		cubeapi.Int64(0x43),
+		cubeapi.String("str"),
+	)
+	require.NoError(t, err)
+
+	exp := []byte{
+		1, 0, 0, 0, 19, 0, 0, 0, 3, 0, 0, 0,
+		0x42, 0, 0, 0,
+		0x43, 0, 0, 0, 0, 0, 0, 0,
+		3, 0, 0, 0, 's', 't', 'r',
+	}
+	require.Equal(t, exp, w.Bytes())
+}
+
+func TestWriteFrameMatchesSendBuffer(t *testing.T) {
+	buf := cubeapi.CreateSendBuffer()
+	buf.WriteInt32(0x42)
+	buf.WriteString("string")
+	buf.WriteHeader(0x1, int32(buf.Len()-cubeapi.HeaderLen))
+
+	w := &bytes.Buffer{}
+	err := cubeapi.CreateEncoder(w).WriteFrame(0x1, 0x0, cubeapi.Int32(0x42), cubeapi.String("string"))
+	require.NoError(t, err)
+	require.Equal(t, buf.Bytes(), w.Bytes())
+}
+
+func TestBodyLen(t *testing.T) {
+	l, err := cubeapi.BodyLen()
+	require.NoError(t, err)
+	require.Equal(t, int32(0), l)
+
+	l, err = cubeapi.BodyLen(cubeapi.Int32(1), cubeapi.Int64(1), cubeapi.String("four"))
+	require.NoError(t, err)
+	require.Equal(t, int32(20), l)
+}
+
+func TestEncoderWriterErr(t *testing.T) {
+	writeErr := errors.New("write failed")
+	e := cubeapi.CreateEncoder(&failingWriter{writeErr})
+	require.True(t, errors.Is(e.WriteFrame(0x1, 0x0, cubeapi.Int32(1)), writeErr))
+	require.True(t, errors.Is(e.WriteString("str"), writeErr))
+}
+
+type failingWriter struct {
+	err error
+}
+
+func (w *failingWriter) Write([]byte) (int, error) {
+	return 0, w.err
+}
@@ -0,0 +1,167 @@
+package cubeapi
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Error is protocol error. Sentinel errors, like ErrNotEnoughData, have
+// message only. Errors returned by parsers, decoders and encoders wrap
+// sentinel or I/O error with operation, field and offset it occurred at,
+// use errors.Is to match sentinel and errors.As to get context
+type Error struct {
+	// Op is operation, outer operations are prepended, e.g.
+	// "read OAUTH2 request: read string"
+	Op string
+	// Field is path of field, outer fields are prepended, e.g.
+	// "body.token", empty if unknown
+	Field string
+	// Offset is offset of field from start of stream, negative if unknown
+	Offset int64
+	// Expected is length of data field needed, zero if unknown
+	Expected int64
+	// Available is length of data that was available for field
+	Available int64
+	// Snippet is data surrounding failed field, it is set only if
+	// snippets are enabled, e.g. by RespBuffer.SetSnippetLen
+	Snippet []byte
+	// SnippetOffset is offset of the first byte of Snippet
+	SnippetOffset int64
+	// Err is wrapped error
+	Err error
+
+	msg string
+}
+
+// CreateError creates sentinel Error with message
+func CreateError(msg string) *Error {
+	return &Error{msg: msg}
+}
+
+// Error implements error interface
+func (e *Error) Error() string {
+	if e.isSentinel() {
+		return e.msg
+	}
+	b := &strings.Builder{}
+	b.WriteString(e.Op)
+	if e.Field != "" {
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		fmt.Fprintf(b, "%q", e.Field)
+	}
+	if e.Offset >= 0 {
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		fmt.Fprintf(b, "at offset %d", e.Offset)
+	}
+	if e.Expected > 0 {
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		fmt.Fprintf(b, "(expected %d bytes, %d available)", e.Expected, e.Available)
+	}
+	if b.Len() > 0 {
+		b.WriteString(": ")
+	}
+	b.WriteString(e.Err.Error())
+	return b.String()
+}
+
+// HexSnippet returns hex dump of Snippet with offsets from start of stream,
+// empty string if there is no snippet
+func (e *Error) HexSnippet() string {
+	if len(e.Snippet) == 0 {
+		return ""
+	}
+	b := &strings.Builder{}
+	for i := 0; i < len(e.Snippet); i += snippetLineLen {
+		end := i + snippetLineLen
+		if end > len(e.Snippet) {
+			end = len(e.Snippet)
+		}
+		fmt.Fprintf(b, "%08x  % x\n", e.SnippetOffset+int64(i), e.Snippet[i:end])
+	}
+	return b.String()
+}
+
+// snippetLineLen is number of bytes in line of HexSnippet
+const snippetLineLen = 16
+
+// Unwrap returns wrapped error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether e is sentinel target, sentinels are compared by
+// message
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && e.isSentinel() && t.isSentinel() && e.msg == t.msg
+}
+
+func (e *Error) isSentinel() bool {
+	return e.Err == nil
+}
+
+// wrapError returns Error of op at offset wrapping err
+func wrapError(op string, offset int64, err error) *Error {
+	return &Error{
+		Op:     op,
+		Offset: offset,
+		Err:    err,
+	}
+}
+
+// WithField returns err annotated with name of field. Field is prepended
+// to path of protocol error, other errors are wrapped
+func WithField(err error, field string) error {
+	if err == nil {
+		return nil
+	}
+	if e, ok := err.(*Error); ok && !e.isSentinel() {
+		c := *e
+		c.Field = joinContext(field, c.Field, ".")
+		return &c
+	}
+	return &Error{Field: field, Offset: -1, Err: err}
+}
+
+// WithOp returns err annotated with operation. Operation is prepended to
+// operation of protocol error, other errors are wrapped
+func WithOp(err error, op string) error {
+	if err == nil {
+		return nil
+	}
+	if e, ok := err.(*Error); ok && !e.isSentinel() {
+		c := *e
+		c.Op = joinContext(op, c.Op, ": ")
+		return &c
+	}
+	return &Error{Op: op, Offset: -1, Err: err}
+}
+
+// joinContext joins outer and inner parts of context omitting empty ones
+func joinContext(outer, inner, sep string) string {
+	switch {
+	case outer == "":
+		return inner
+	case inner == "":
+		return outer
+	}
+	return outer + sep + inner
+}
+
+// Cause returns sentinel err wraps, nil if err isn't protocol error
+func Cause(err error) *Error {
+	for err != nil {
+		if e, ok := err.(*Error); ok && e.isSentinel() {
+			return e
+		}
+		err = errors.Unwrap(err)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package cubeapi_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/Apakhov/cube/cubeapi"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorMessage(t *testing.T) {
+	require.Equal(t, "Not enough data", cubeapi.ErrNotEnoughData.Error())
+
+	err := cubeapi.WithField(cubeapi.WithOp(&cubeapi.Error{Offset: 16, Err: cubeapi.ErrNotEnoughData}, "read string"), "token")
+	require.Equal(t, `read string "token" at offset 16: Not enough data`, err.Error())
+
+	err = cubeapi.WithOp(err, "read request")
+	require.Equal(t, `read request: read string "token" at offset 16: Not enough data`, err.Error())
+
+	err = cubeapi.WithField(io.ErrClosedPipe, "scope")
+	require.Equal(t, `"scope": io: read/write on closed pipe`, err.Error())
+
+	err = cubeapi.WithField(cubeapi.WithField(&cubeapi.Error{Offset: 20, Expected: 8, Available: 2, Err: cubeapi.ErrNotEnoughData}, "id"), "user")
+	require.Equal(t, `"user.id" at offset 20 (expected 8 bytes, 2 available): Not enough data`, err.Error())
+
+	require.Nil(t, cubeapi.WithField(nil, "scope"))
+	require.Nil(t, cubeapi.WithOp(nil, "read request"))
+}
+
+func TestErrorIs(t *testing.T) {
+	err := cubeapi.WithOp(cubeapi.WithField(&cubeapi.Error{Offset: 4, Err: cubeapi.ErrIncorrectLen}, "scope"), "read request")
+	wrapped := fmt.Errorf("failed to read: %w", err)
+	require.True(t, errors.Is(wrapped, cubeapi.ErrIncorrectLen))
+	require.False(t, errors.Is(wrapped, cubeapi.ErrNotEnoughData))
+	require.True(t, errors.Is(cubeapi.CreateError(cubeapi.ErrIncorrectLen.Error()), cubeapi.ErrIncorrectLen))
+	require.Equal(t, cubeapi.ErrIncorrectLen, cubeapi.Cause(wrapped))
+	require.Nil(t, cubeapi.Cause(io.ErrClosedPipe))
+	require.Nil(t, cubeapi.Cause(nil))
+
+	var e *cubeapi.Error
+	require.True(t, errors.As(wrapped, &e))
+	require.Equal(t, "read request", e.Op)
+	require.Equal(t, "scope", e.Field)
+	require.Equal(t, int64(4), e.Offset)
+}
+
+func TestDecoderErrorOffset(t *testing.T) {
+	testBytes := []byte{
+		0x2, 0, 0, 0, 0x10, 0, 0, 0, 0x1, 0, 0, 0,
+		0x1, 0, 0, 0,
+		0x2, 0, 0, 0, 'a',
+	}
+	d := cubeapi.CreateDecoder(bytes.NewReader(testBytes))
+	require.NoError(t, d.ReadHeader(&cubeapi.Header{}))
+	var i int32
+	require.NoError(t, d.ReadInt32(&i))
+	require.Equal(t, int64(16), d.Offset())
+
+	var s string
+	err := d.ReadString(&s)
+	require.True(t, errors.Is(err, cubeapi.ErrNotEnoughData))
+	var e *cubeapi.Error
+	require.True(t, errors.As(err, &e))
+	require.Equal(t, "read string", e.Op)
+	require.Equal(t, int64(16), e.Offset)
+	require.Equal(t, int64(2), e.Expected)
+	require.Equal(t, int64(1), e.Available)
+
+	d = cubeapi.CreateDecoder(bytes.NewReader([]byte{0x2, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, 0x1, 0, 0, 0}))
+	_, err = d.ReadFrame(&cubeapi.Header{})
+	require.True(t, errors.Is(err, cubeapi.ErrIncorrectBodyLen))
+	require.True(t, errors.As(err, &e))
+	require.Equal(t, int64(cubeapi.HeaderLen), e.Offset)
+}
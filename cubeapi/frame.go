@@ -0,0 +1,37 @@
+package cubeapi
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// MaxBodyLen limits body length of frame accepted by ReadFrame
+const MaxBodyLen = 1 << 20
+
+// ReadFrame reads exactly one frame: header and body of BodyLength bytes
+func ReadFrame(r io.Reader) ([]byte, error) {
+	frame := make([]byte, HeaderLen)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return nil, err
+	}
+	bodyLen := int32(binary.LittleEndian.Uint32(frame[4:8]))
+	if bodyLen < 0 || bodyLen > MaxBodyLen {
+		return nil, wrapError("read frame", 4, fmt.Errorf("body length %d: %w", bodyLen, ErrIncorrectBodyLen))
+	}
+	frame = append(frame, make([]byte, bodyLen)...)
+	if _, err := io.ReadFull(r, frame[HeaderLen:]); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// FrameRequestID returns RequestID of frame
+func FrameRequestID(frame []byte) int32 {
+	return int32(binary.LittleEndian.Uint32(frame[8:12]))
+}
+
+// SetFrameRequestID sets RequestID of frame
+func SetFrameRequestID(frame []byte, requestID int32) {
+	binary.LittleEndian.PutUint32(frame[8:12], uint32(requestID))
+}
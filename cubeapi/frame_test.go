@@ -0,0 +1,47 @@
+package cubeapi_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/Apakhov/cube/cubeapi"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadFrame(t *testing.T) {
+	frames := append(buildFrame(3, 42), buildFrame(4, 43)...)
+	r := bytes.NewReader(append(frames, 0x1))
+
+	frame, err := cubeapi.ReadFrame(r)
+	require.NoError(t, err)
+	require.Equal(t, buildFrame(3, 42), frame)
+	require.Equal(t, int32(3), cubeapi.FrameRequestID(frame))
+
+	frame, err = cubeapi.ReadFrame(r)
+	require.NoError(t, err)
+	require.Equal(t, buildFrame(4, 43), frame)
+
+	_, err = cubeapi.ReadFrame(r)
+	require.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestReadFrameErr(t *testing.T) {
+	frame := buildFrame(3, 42)
+	binary.LittleEndian.PutUint32(frame[4:8], 0xFFFFFFFF)
+	_, err := cubeapi.ReadFrame(bytes.NewReader(frame))
+	require.True(t, errors.Is(err, cubeapi.ErrIncorrectBodyLen))
+
+	frame = buildFrame(3, 42)
+	_, err = cubeapi.ReadFrame(bytes.NewReader(frame[:14]))
+	require.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestSetFrameRequestID(t *testing.T) {
+	frame := buildFrame(3, 42)
+	cubeapi.SetFrameRequestID(frame, 0x01020304)
+	require.Equal(t, int32(0x01020304), cubeapi.FrameRequestID(frame))
+	require.Equal(t, int32(42), frameValue(frame))
+}
@@ -0,0 +1,228 @@
+package cubeapi
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// MuxConn multiplexes requests over one connection. Every request gets
+// unique RequestID, reader go-routine dispatches responses to waiting
+// callers by RequestID
+type MuxConn struct {
+	conn    net.Conn
+	conf    MuxConfig
+	wlock   sync.Mutex
+	lock    sync.Mutex
+	pending map[int32]chan []byte
+	lastID  int32
+	err     error
+	done    chan struct{}
+}
+
+// MuxConfig configures MuxConn
+type MuxConfig struct {
+	// OnError is called for responses no request is waiting for, it can be
+	// nil
+	OnError func(error)
+	// KeepOnInterruptedWrite keeps connection after write interrupted by
+	// context before any byte is written, otherwise connection is closed.
+	// It can be set only for connections usable after expired write
+	// deadline, like plain tcp ones but not TLS ones
+	KeepOnInterruptedWrite bool
+}
+
+// CreateMuxConn creates MuxConn and starts reading responses from conn.
+// onError is called for responses no request is waiting for, it can be nil
+func CreateMuxConn(conn net.Conn, onError func(error)) *MuxConn {
+	return CreateMuxConnWithConfig(conn, MuxConfig{OnError: onError})
+}
+
+// CreateMuxConnWithConfig creates MuxConn configured by conf and starts
+// reading responses from conn
+func CreateMuxConnWithConfig(conn net.Conn, conf MuxConfig) *MuxConn {
+	m := &MuxConn{
+		conn:    conn,
+		conf:    conf,
+		pending: make(map[int32]chan []byte),
+		done:    make(chan struct{}),
+	}
+	go m.readLoop()
+	return m
+}
+
+// RoundTrip sends request frame and waits for response with the same
+// RequestID. Copy of req with unique RequestID is sent, so the same req can
+// be sent concurrently. Context cancellation and deadline interrupt
+// writing, connection is closed if the frame is written partially, see
+// MuxConfig.KeepOnInterruptedWrite
+func (m *MuxConn) RoundTrip(ctx context.Context, req []byte) ([]byte, error) {
+	id, resp, err := m.register()
+	if err != nil {
+		return nil, err
+	}
+	req = append([]byte(nil), req...)
+	SetFrameRequestID(req, id)
+
+	if n, err := m.write(ctx, req); err != nil {
+		m.unregister(id)
+		ctxErr := contextError(ctx)
+		if n > 0 || ctxErr == nil || !m.conf.KeepOnInterruptedWrite {
+			// connection is broken or the rest of frame is lost
+			m.fail(err)
+		}
+		if ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, err
+	}
+
+	select {
+	case frame, ok := <-resp:
+		if !ok {
+			return nil, m.Err()
+		}
+		return frame, nil
+	case <-ctx.Done():
+		m.unregister(id)
+		return nil, ctx.Err()
+	}
+}
+
+func (m *MuxConn) register() (int32, chan []byte, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.err != nil {
+		return 0, nil, m.err
+	}
+	for {
+		m.lastID++
+		if m.lastID <= 0 {
+			m.lastID = 1
+		}
+		if _, busy := m.pending[m.lastID]; !busy {
+			break
+		}
+	}
+	resp := make(chan []byte, 1)
+	m.pending[m.lastID] = resp
+	return m.lastID, resp, nil
+}
+
+func (m *MuxConn) unregister(id int32) {
+	m.lock.Lock()
+	delete(m.pending, id)
+	m.lock.Unlock()
+}
+
+// write writes req, context deadline and cancellation interrupt writing.
+// It returns number of bytes written
+func (m *MuxConn) write(ctx context.Context, req []byte) (int, error) {
+	m.wlock.Lock()
+	defer m.wlock.Unlock()
+	if err := contextError(ctx); err != nil {
+		return 0, err
+	}
+	deadline, _ := ctx.Deadline()
+	m.conn.SetWriteDeadline(deadline)
+	var stopped chan struct{}
+	done := make(chan struct{})
+	if ctx.Done() != nil {
+		stopped = make(chan struct{})
+		go func() {
+			defer close(stopped)
+			select {
+			case <-ctx.Done():
+				m.conn.SetWriteDeadline(time.Unix(1, 0))
+			case <-done:
+			}
+		}()
+	}
+	n, err := m.conn.Write(req)
+	close(done)
+	if stopped != nil {
+		<-stopped
+	}
+	m.conn.SetWriteDeadline(time.Time{})
+	if err != nil {
+		return n, fmt.Errorf("failed to write to connection: %w", err)
+	}
+	return n, nil
+}
+
+// contextError returns ctx.Err(), reporting context.DeadlineExceeded as soon
+// as deadline is passed since conn deadline may expire before context's one
+func contextError(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+		return context.DeadlineExceeded
+	}
+	return nil
+}
+
+func (m *MuxConn) readLoop() {
+	for {
+		frame, err := ReadFrame(m.conn)
+		if err != nil {
+			m.fail(fmt.Errorf("failed to read from connection: %w", err))
+			return
+		}
+		id := FrameRequestID(frame)
+		m.lock.Lock()
+		resp, ok := m.pending[id]
+		delete(m.pending, id)
+		m.lock.Unlock()
+		if !ok {
+			if m.conf.OnError != nil {
+				m.conf.OnError(fmt.Errorf("failed to dispatch response %d: %w", id, ErrUnknownRequestID))
+			}
+			continue
+		}
+		resp <- frame
+	}
+}
+
+// fail closes connection and fails all waiting requests with err
+func (m *MuxConn) fail(err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.err != nil {
+		return
+	}
+	m.err = err
+	m.conn.Close()
+	for id, resp := range m.pending {
+		close(resp)
+		delete(m.pending, id)
+	}
+	close(m.done)
+}
+
+// Pending returns number of requests waiting for response
+func (m *MuxConn) Pending() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return len(m.pending)
+}
+
+// Err returns error connection failed with
+func (m *MuxConn) Err() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.err
+}
+
+// Done returns channel closed after connection failure or Close
+func (m *MuxConn) Done() <-chan struct{} {
+	return m.done
+}
+
+// Close closes connection, waiting requests fail with ErrMuxClosed
+func (m *MuxConn) Close() error {
+	m.fail(ErrMuxClosed)
+	return nil
+}
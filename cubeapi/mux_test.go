@@ -0,0 +1,210 @@
+package cubeapi_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Apakhov/cube/cubeapi"
+	"github.com/stretchr/testify/require"
+)
+
+// buildFrame builds frame with svc id 1 and body consisting of one int32
+func buildFrame(requestID, value int32) []byte {
+	frame := make([]byte, cubeapi.HeaderLen+4)
+	binary.LittleEndian.PutUint32(frame[0:4], 1)
+	binary.LittleEndian.PutUint32(frame[4:8], 4)
+	binary.LittleEndian.PutUint32(frame[8:12], uint32(requestID))
+	binary.LittleEndian.PutUint32(frame[12:16], uint32(value))
+	return frame
+}
+
+func frameValue(frame []byte) int32 {
+	return int32(binary.LittleEndian.Uint32(frame[12:16]))
+}
+
+// serveReversed reads n requests and answers them in reverse order echoing
+// request value
+func serveReversed(conn net.Conn, n int) {
+	reqs := [][]byte{}
+	for i := 0; i < n; i++ {
+		frame, err := cubeapi.ReadFrame(conn)
+		if err != nil {
+			return
+		}
+		reqs = append(reqs, frame)
+	}
+	for i := len(reqs) - 1; i >= 0; i-- {
+		conn.Write(reqs[i])
+	}
+}
+
+func TestMuxConnDispatch(t *testing.T) {
+	client, server := net.Pipe()
+	go serveReversed(server, 10)
+	m := cubeapi.CreateMuxConn(client, nil)
+	defer m.Close()
+
+	wg := &sync.WaitGroup{}
+	ids := make(chan int32, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int32) {
+			defer wg.Done()
+			req := buildFrame(0, i)
+			resp, err := m.RoundTrip(context.Background(), req)
+			require.NoError(t, err)
+			require.Equal(t, i, frameValue(resp))
+			// req isn't modified
+			require.Equal(t, int32(0), cubeapi.FrameRequestID(req))
+			ids <- cubeapi.FrameRequestID(resp)
+		}(int32(i))
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := map[int32]bool{}
+	for id := range ids {
+		require.False(t, seen[id], "request id is not unique")
+		seen[id] = true
+	}
+	require.Equal(t, 0, m.Pending())
+}
+
+func TestMuxConnUnknownID(t *testing.T) {
+	client, server := net.Pipe()
+	errs := make(chan error, 1)
+	m := cubeapi.CreateMuxConn(client, func(err error) { errs <- err })
+	defer m.Close()
+
+	go func() {
+		frame, err := cubeapi.ReadFrame(server)
+		if err != nil {
+			return
+		}
+		server.Write(buildFrame(cubeapi.FrameRequestID(frame)+100, 0))
+		server.Write(frame)
+	}()
+
+	_, err := m.RoundTrip(context.Background(), buildFrame(0, 42))
+	require.NoError(t, err)
+	require.True(t, errors.Is(<-errs, cubeapi.ErrUnknownRequestID))
+}
+
+func TestMuxConnCancel(t *testing.T) {
+	client, server := net.Pipe()
+	errs := make(chan error, 1)
+	m := cubeapi.CreateMuxConn(client, func(err error) { errs <- err })
+	defer m.Close()
+
+	reqs := make(chan []byte, 1)
+	go func() {
+		frame, err := cubeapi.ReadFrame(server)
+		if err != nil {
+			return
+		}
+		reqs <- frame
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	_, err := m.RoundTrip(ctx, buildFrame(0, 42))
+	require.Equal(t, context.DeadlineExceeded, err)
+	require.Equal(t, 0, m.Pending())
+
+	// late response becomes orphan
+	server.Write(<-reqs)
+	require.True(t, errors.Is(<-errs, cubeapi.ErrUnknownRequestID))
+}
+
+func TestMuxConnWriteInterrupted(t *testing.T) {
+	client, server := net.Pipe()
+	m := cubeapi.CreateMuxConnWithConfig(client, cubeapi.MuxConfig{KeepOnInterruptedWrite: true})
+	defer m.Close()
+
+	// in-flight request survives interrupted write of other one
+	inflight := make(chan error, 1)
+	go func() {
+		_, err := m.RoundTrip(context.Background(), buildFrame(0, 1))
+		inflight <- err
+	}()
+	first, err := cubeapi.ReadFrame(server)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	_, err = m.RoundTrip(ctx, buildFrame(0, 2))
+	require.Equal(t, context.DeadlineExceeded, err)
+
+	// cancellation without deadline interrupts writing too
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(time.Millisecond * 20)
+		cancel()
+	}()
+	_, err = m.RoundTrip(ctx, buildFrame(0, 3))
+	require.Equal(t, context.Canceled, err)
+	require.NoError(t, m.Err())
+
+	_, err = server.Write(first)
+	require.NoError(t, err)
+	require.NoError(t, <-inflight)
+
+	// partially written frame breaks connection
+	go func() {
+		server.Read(make([]byte, 4))
+	}()
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	_, err = m.RoundTrip(ctx, buildFrame(0, 4))
+	require.Equal(t, context.DeadlineExceeded, err)
+	<-m.Done()
+}
+
+func TestMuxConnWriteInterruptedClose(t *testing.T) {
+	client, _ := net.Pipe()
+	m := cubeapi.CreateMuxConn(client, nil)
+	defer m.Close()
+
+	// connection isn't kept by default
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	_, err := m.RoundTrip(ctx, buildFrame(0, 1))
+	require.Equal(t, context.DeadlineExceeded, err)
+	<-m.Done()
+}
+
+func TestMuxConnFail(t *testing.T) {
+	client, server := net.Pipe()
+	m := cubeapi.CreateMuxConn(client, nil)
+
+	go func() {
+		cubeapi.ReadFrame(server)
+		server.Close()
+	}()
+
+	_, err := m.RoundTrip(context.Background(), buildFrame(0, 42))
+	require.Error(t, err)
+	<-m.Done()
+
+	_, err = m.RoundTrip(context.Background(), buildFrame(0, 42))
+	require.Error(t, err)
+	require.Equal(t, m.Err(), err)
+}
+
+func TestMuxConnClose(t *testing.T) {
+	client, server := net.Pipe()
+	m := cubeapi.CreateMuxConn(client, nil)
+	go cubeapi.ReadFrame(server)
+
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		m.Close()
+	}()
+	_, err := m.RoundTrip(context.Background(), buildFrame(0, 42))
+	require.Equal(t, cubeapi.ErrMuxClosed, err)
+}
@@ -0,0 +1,224 @@
+package oauth2
+
+import (
+	"fmt"
+	"time"
+)
+
+// BreakerState is state of circuit breaker of endpoint
+type BreakerState int
+
+const (
+	// BreakerClosed lets requests to endpoint through
+	BreakerClosed BreakerState = iota
+	// BreakerOpen fails requests to endpoint fast
+	BreakerOpen
+	// BreakerHalfOpen lets trial requests through, their success closes
+	// breaker and failure opens it again
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("BreakerState(%d)", int(s))
+}
+
+// defaults of BreakerConfig
+const (
+	DefaultBreakerMinRequests = 5
+	DefaultBreakerFailureRate = 0.5
+	DefaultBreakerOpenTime    = 5 * time.Second
+)
+
+// BreakerConfig configures circuit breakers of endpoints. Breaker opens
+// when failure rate of requests over sliding Window reaches FailureRate,
+// requests to endpoint fail fast with ErrCircuitOpen for OpenTime then.
+// Connection failures, malformed responses, CubeOAUTH2ErrCodeDBError and
+// exceeded deadlines are failures
+type BreakerConfig struct {
+	// Window is length of sliding window failure rate is computed over,
+	// breakers are disabled if zero
+	Window time.Duration
+	// MinRequests is number of requests in window needed to open breaker,
+	// DefaultBreakerMinRequests if zero
+	MinRequests int
+	// FailureRate is ratio of failed requests opening breaker,
+	// DefaultBreakerFailureRate if zero
+	FailureRate float64
+	// OpenTime is time breaker stays open before letting trial requests
+	// through, DefaultBreakerOpenTime if zero
+	OpenTime time.Duration
+	// HalfOpenRequests is number of successful trial requests closing
+	// breaker, 1 if zero
+	HalfOpenRequests int
+	// OnStateChange is called on every change of state of breaker of
+	// endpoint at address, it can be nil
+	OnStateChange func(address string, from, to BreakerState)
+}
+
+// breakerBuckets is number of buckets of sliding window
+const breakerBuckets = 10
+
+// breakerBucket counts requests started in the same part of window
+type breakerBucket struct {
+	start time.Time
+	total int
+	fails int
+}
+
+// stateChange is change of state of breaker of endpoint
+type stateChange struct {
+	address  string
+	from, to BreakerState
+}
+
+// breaker is circuit breaker of endpoint, it is guarded by lock of
+// endpointSet
+type breaker struct {
+	conf      *BreakerConfig
+	state     BreakerState
+	openedAt  time.Time
+	buckets   [breakerBuckets]breakerBucket
+	trials    int
+	successes int
+}
+
+// createBreaker creates breaker, nil if breakers are disabled
+func createBreaker(conf *BreakerConfig) *breaker {
+	if conf.Window <= 0 {
+		return nil
+	}
+	return &breaker{conf: conf}
+}
+
+// withBreakerDefaults returns conf with defaults set
+func withBreakerDefaults(conf BreakerConfig) BreakerConfig {
+	if conf.MinRequests <= 0 {
+		conf.MinRequests = DefaultBreakerMinRequests
+	}
+	if conf.FailureRate <= 0 {
+		conf.FailureRate = DefaultBreakerFailureRate
+	}
+	if conf.OpenTime <= 0 {
+		conf.OpenTime = DefaultBreakerOpenTime
+	}
+	if conf.HalfOpenRequests <= 0 {
+		conf.HalfOpenRequests = 1
+	}
+	return conf
+}
+
+// permits reports whether request can be sent at now
+func (b *breaker) permits(now time.Time) bool {
+	if b == nil {
+		return true
+	}
+	switch b.state {
+	case BreakerOpen:
+		return !now.Before(b.openedAt.Add(b.conf.OpenTime))
+	case BreakerHalfOpen:
+		return b.trials+b.successes < b.conf.HalfOpenRequests
+	}
+	return true
+}
+
+// acquire takes permitted request, open breaker becomes half-open
+func (b *breaker) acquire(now time.Time) (from BreakerState, changed bool) {
+	if b == nil {
+		return BreakerClosed, false
+	}
+	from = b.state
+	if b.state == BreakerOpen {
+		b.state = BreakerHalfOpen
+		b.trials, b.successes = 0, 0
+	}
+	if b.state == BreakerHalfOpen {
+		b.trials++
+	}
+	return from, from != b.state
+}
+
+// record records result of request, cancelled requests are only released
+func (b *breaker) record(now time.Time, failed, cancelled bool) (from BreakerState, changed bool) {
+	if b == nil {
+		return BreakerClosed, false
+	}
+	from = b.state
+	switch b.state {
+	case BreakerClosed:
+		if cancelled {
+			break
+		}
+		bucket := b.bucket(now)
+		bucket.total++
+		if failed {
+			bucket.fails++
+			total, fails := b.counts(now)
+			if total >= b.conf.MinRequests && float64(fails) >= b.conf.FailureRate*float64(total) {
+				b.open(now)
+			}
+		}
+	case BreakerHalfOpen:
+		if b.trials > 0 {
+			b.trials--
+		}
+		switch {
+		case cancelled:
+		case failed:
+			b.open(now)
+		default:
+			b.successes++
+			if b.successes >= b.conf.HalfOpenRequests {
+				b.state = BreakerClosed
+				b.buckets = [breakerBuckets]breakerBucket{}
+			}
+		}
+	}
+	return from, from != b.state
+}
+
+func (b *breaker) open(now time.Time) {
+	b.state = BreakerOpen
+	b.openedAt = now
+	b.trials, b.successes = 0, 0
+}
+
+// bucket returns bucket of window requests at now are counted in
+func (b *breaker) bucket(now time.Time) *breakerBucket {
+	width := b.conf.Window / breakerBuckets
+	if width <= 0 {
+		width = 1
+	}
+	start := now.Truncate(width)
+	bucket := &b.buckets[(start.UnixNano()/int64(width))%breakerBuckets]
+	if !bucket.start.Equal(start) {
+		*bucket = breakerBucket{start: start}
+	}
+	return bucket
+}
+
+// counts returns numbers of all and failed requests in window ending at now
+func (b *breaker) counts(now time.Time) (total, fails int) {
+	for _, bucket := range b.buckets {
+		if now.Sub(bucket.start) < b.conf.Window {
+			total += bucket.total
+			fails += bucket.fails
+		}
+	}
+	return total, fails
+}
+
+// currentState returns state of breaker, closed if breakers are disabled
+func (b *breaker) currentState() BreakerState {
+	if b == nil {
+		return BreakerClosed
+	}
+	return b.state
+}
@@ -0,0 +1,172 @@
+package oauth2_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Apakhov/cube/cubeapi/oauth2"
+	"github.com/Apakhov/cube/cubeapi/oauth2/oauth2test"
+	"github.com/stretchr/testify/require"
+)
+
+// stateRecorder records changes of breaker states
+type stateRecorder struct {
+	lock    sync.Mutex
+	changes []oauth2.BreakerState
+}
+
+func (sr *stateRecorder) record(address string, from, to oauth2.BreakerState) {
+	sr.lock.Lock()
+	defer sr.lock.Unlock()
+	sr.changes = append(sr.changes, to)
+}
+
+func (sr *stateRecorder) get() []oauth2.BreakerState {
+	sr.lock.Lock()
+	defer sr.lock.Unlock()
+	return append([]oauth2.BreakerState(nil), sr.changes...)
+}
+
+func startBreakerServer(conf oauth2.BreakerConfig) (*oauth2test.Server, *oauth2.Client) {
+	s := oauth2test.CreateServer()
+	s.AddToken("token", "scope", oauth2.ResponseOAUTH2{Username: "testuser@mail.ru"})
+	c := s.ClientConfig()
+	c.Breaker = conf
+	return s, oauth2.CreateClient(c)
+}
+
+func TestBreakerOpenClose(t *testing.T) {
+	sr := &stateRecorder{}
+	s, client := startBreakerServer(oauth2.BreakerConfig{
+		Window:        time.Second,
+		MinRequests:   3,
+		OpenTime:      100 * time.Millisecond,
+		OnStateChange: sr.record,
+	})
+	defer s.Close()
+	defer client.Close()
+
+	s.SetFaults(oauth2test.Fault{Close: true})
+	for i := 0; i < 3; i++ {
+		_, err := client.Validate(context.Background(), "token", "scope")
+		require.True(t, errors.Is(err, oauth2.ErrNotEnoughData))
+	}
+	require.Equal(t, oauth2.BreakerOpen, client.Endpoints()[0].Breaker)
+	_, err := client.Validate(context.Background(), "token", "scope")
+	require.Equal(t, oauth2.ErrCircuitOpen, err)
+	require.False(t, oauth2.IsRetryable(err))
+	require.Equal(t, 3, s.Requests())
+
+	// failed trial opens breaker again
+	time.Sleep(150 * time.Millisecond)
+	_, err = client.Validate(context.Background(), "token", "scope")
+	require.True(t, errors.Is(err, oauth2.ErrNotEnoughData))
+	require.Equal(t, oauth2.BreakerOpen, client.Endpoints()[0].Breaker)
+
+	// successful trial closes breaker
+	s.SetFaults()
+	time.Sleep(150 * time.Millisecond)
+	r, err := client.Validate(context.Background(), "token", "scope")
+	require.NoError(t, err)
+	require.Equal(t, "testuser@mail.ru", r.Username)
+	require.Equal(t, oauth2.BreakerClosed, client.Endpoints()[0].Breaker)
+
+	require.Equal(t, []oauth2.BreakerState{
+		oauth2.BreakerOpen, oauth2.BreakerHalfOpen, oauth2.BreakerOpen,
+		oauth2.BreakerHalfOpen, oauth2.BreakerClosed,
+	}, sr.get())
+}
+
+func TestBreakerFailureRate(t *testing.T) {
+	s, client := startBreakerServer(oauth2.BreakerConfig{
+		Window:      time.Second,
+		MinRequests: 4,
+		FailureRate: 0.5,
+	})
+	defer s.Close()
+	defer client.Close()
+
+	s.SetReturnCode("dberror", "scope", oauth2.CubeOAUTH2ErrCodeDBError)
+	for i := 0; i < 2; i++ {
+		_, err := client.Validate(context.Background(), "token", "scope")
+		require.NoError(t, err)
+	}
+	// token not found is answer of healthy server
+	_, err := client.Validate(context.Background(), "unknown", "scope")
+	require.NoError(t, err)
+	_, err = client.Validate(context.Background(), "dberror", "scope")
+	require.NoError(t, err)
+	require.Equal(t, oauth2.BreakerClosed, client.Endpoints()[0].Breaker)
+
+	_, err = client.Validate(context.Background(), "dberror", "scope")
+	require.NoError(t, err)
+	_, err = client.Validate(context.Background(), "dberror", "scope")
+	require.NoError(t, err)
+	require.Equal(t, oauth2.BreakerOpen, client.Endpoints()[0].Breaker)
+}
+
+func TestBreakerTimeout(t *testing.T) {
+	s, client := startBreakerServer(oauth2.BreakerConfig{Window: time.Second, MinRequests: 2})
+	defer s.Close()
+	defer client.Close()
+
+	s.SetFaults(oauth2test.Fault{Delay: 200 * time.Millisecond})
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		_, err := client.Validate(ctx, "token", "scope")
+		cancel()
+		require.Equal(t, context.DeadlineExceeded, err)
+	}
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := client.Validate(ctx, "token", "scope")
+	require.Equal(t, oauth2.ErrCircuitOpen, err)
+	require.True(t, time.Since(start) < 100*time.Millisecond)
+}
+
+func TestBreakerTimeoutCoalesce(t *testing.T) {
+	s := oauth2test.CreateServer()
+	defer s.Close()
+	s.AddToken("token", "scope", oauth2.ResponseOAUTH2{Username: "testuser@mail.ru"})
+	conf := s.ClientConfig()
+	conf.Breaker = oauth2.BreakerConfig{Window: time.Second, MinRequests: 2}
+	conf.Coalesce = true
+	client := oauth2.CreateClient(conf)
+	defer client.Close()
+
+	// requests abandoned by callers timed out are timeouts of endpoint
+	s.SetFaults(oauth2test.Fault{Delay: 200 * time.Millisecond})
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		_, err := client.Validate(ctx, "token", "scope")
+		cancel()
+		require.Equal(t, context.DeadlineExceeded, err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	require.Equal(t, oauth2.BreakerOpen, client.Endpoints()[0].Breaker)
+	_, err := client.Validate(context.Background(), "token", "scope")
+	require.Equal(t, oauth2.ErrCircuitOpen, err)
+}
+
+func TestBreakerFailover(t *testing.T) {
+	servers, addrs := startReplicas(2)
+	defer closeReplicas(servers)
+	servers[0].SetFaults(oauth2test.Fault{Close: true})
+	client := oauth2.CreateClient(oauth2.ClientConfig{
+		Endpoints: addrs,
+		Breaker:   oauth2.BreakerConfig{Window: time.Second, MinRequests: 1},
+	})
+	defer client.Close()
+
+	for i := 0; i < 4; i++ {
+		_, err := client.Validate(context.Background(), "token", "scope")
+		require.NoError(t, err)
+	}
+	require.Equal(t, 1, servers[0].Requests())
+	require.Equal(t, 4, servers[1].Requests())
+	require.Equal(t, oauth2.BreakerOpen, client.Endpoints()[0].Breaker)
+}
@@ -0,0 +1,235 @@
+package oauth2
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// CacheConfig configures Cache
+type CacheConfig struct {
+	// MaxTTL limits time response is cached for, ExpiresIn of response is
+	// only limit if zero
+	MaxTTL time.Duration
+	// MaxSize limits number of cached responses, DefaultCacheSize if zero
+	MaxSize int
+	// NegativeTTL is time definitive negative answers are cached for:
+	// CubeOAUTH2ErrCodeTokenNotFound, CubeOAUTH2ErrCodeBadScope and
+	// CubeOAUTH2ErrCodeBadClient. They aren't cached if zero
+	NegativeTTL time.Duration
+}
+
+// DefaultCacheSize is default number of cached responses
+const DefaultCacheSize = 1024
+
+// CacheStats represents cache statistics
+type CacheStats struct {
+	// Hits is number of validations answered from cache
+	Hits int
+	// Misses is number of validations passed to Validator
+	Misses int
+	// Size is number of cached responses
+	Size int
+	// Evictions is number of responses evicted as least recently used
+	Evictions int
+}
+
+type cacheKey struct {
+	token string
+	scope string
+}
+
+// pendingToken tracks validations of token in progress. Invalidate bumps
+// generation, so results of validations started before aren't cached
+type pendingToken struct {
+	validations int
+	generation  uint64
+}
+
+type cacheEntry struct {
+	key     cacheKey
+	resp    ResponseOAUTH2
+	err     error
+	expires time.Time
+}
+
+// Cache caches successful validations of Validator by token and scope until
+// min(ExpiresIn, MaxTTL) passes, and definitive negative answers for
+// NegativeTTL, whether they are responses or ReturnCodeError. Transient
+// errors, like CubeOAUTH2ErrCodeDBError or other errors of Validator, are
+// never cached. Least recently used responses are evicted
+// when MaxSize is reached
+type Cache struct {
+	v       Validator
+	conf    CacheConfig
+	lock    sync.Mutex
+	lru     *list.List
+	entries map[string]map[string]*list.Element
+	pending map[string]*pendingToken
+	stats   CacheStats
+}
+
+// CreateCache creates Cache in front of v
+func CreateCache(v Validator, conf CacheConfig) *Cache {
+	if conf.MaxSize <= 0 {
+		conf.MaxSize = DefaultCacheSize
+	}
+	return &Cache{
+		v:       v,
+		conf:    conf,
+		lru:     list.New(),
+		entries: make(map[string]map[string]*list.Element),
+		pending: make(map[string]*pendingToken),
+	}
+}
+
+// Validate returns cached response to token with scope or validates it
+// with Validator. Returned response can be modified by caller. Response
+// isn't cached if token is invalidated during validation
+func (c *Cache) Validate(ctx context.Context, token, scope string) (*ResponseOAUTH2, error) {
+	key := cacheKey{token: token, scope: scope}
+	if r, ok, err := c.get(key); ok {
+		return r, err
+	}
+	generation := c.begin(token)
+	r, err := c.v.Validate(ctx, token, scope)
+	resp, respErr := ResponseOf(r, err)
+	if respErr != nil {
+		c.lock.Lock()
+		c.end(token, generation)
+		c.lock.Unlock()
+		return nil, err
+	}
+	c.put(key, resp, err, generation)
+	return r, err
+}
+
+// Invalidate removes cached responses to token with any scope, responses
+// of validations in progress aren't cached
+func (c *Cache) Invalidate(token string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if p, ok := c.pending[token]; ok {
+		p.generation++
+	}
+	for _, e := range c.entries[token] {
+		c.lru.Remove(e)
+	}
+	delete(c.entries, token)
+}
+
+// Stats returns statistics of cache
+func (c *Cache) Stats() CacheStats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	stats := c.stats
+	stats.Size = c.lru.Len()
+	return stats
+}
+
+// get returns cached response or ReturnCodeError, ok is false if there
+// is no cached one
+func (c *Cache) get(key cacheKey) (r *ResponseOAUTH2, ok bool, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	e, ok := c.entries[key.token][key.scope]
+	if !ok {
+		c.stats.Misses++
+		return nil, false, nil
+	}
+	entry := e.Value.(*cacheEntry)
+	if !time.Now().Before(entry.expires) {
+		c.remove(e)
+		c.stats.Misses++
+		return nil, false, nil
+	}
+	c.lru.MoveToFront(e)
+	c.stats.Hits++
+	if entry.err != nil {
+		return nil, true, entry.err
+	}
+	resp := entry.resp
+	return &resp, true, nil
+}
+
+// begin registers validation of token in progress and returns generation
+// of token
+func (c *Cache) begin(token string) uint64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	p, ok := c.pending[token]
+	if !ok {
+		p = &pendingToken{}
+		c.pending[token] = p
+	}
+	p.validations++
+	return p.generation
+}
+
+// end unregisters validation of token started at generation and reports
+// whether token is invalidated since. Must be called under lock
+func (c *Cache) end(token string, generation uint64) (invalidated bool) {
+	p := c.pending[token]
+	p.validations--
+	if p.validations == 0 {
+		delete(c.pending, token)
+	}
+	return p.generation != generation
+}
+
+// put caches response r of validation started at generation, err is
+// ReturnCodeError if Validator returned it instead of response
+func (c *Cache) put(key cacheKey, r *ResponseOAUTH2, err error, generation uint64) {
+	ttl := c.ttl(r)
+	entry := &cacheEntry{key: key, resp: *r, err: err, expires: time.Now().Add(ttl)}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if invalidated := c.end(key.token, generation); invalidated || ttl <= 0 {
+		return
+	}
+	if e, ok := c.entries[key.token][key.scope]; ok {
+		e.Value = entry
+		c.lru.MoveToFront(e)
+		return
+	}
+	for c.lru.Len() >= c.conf.MaxSize {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+	scopes, ok := c.entries[key.token]
+	if !ok {
+		scopes = make(map[string]*list.Element)
+		c.entries[key.token] = scopes
+	}
+	scopes[key.scope] = c.lru.PushFront(entry)
+}
+
+// ttl returns time response can be cached for, not positive if it
+// shouldn't be cached
+func (c *Cache) ttl(r *ResponseOAUTH2) time.Duration {
+	switch r.ReturnCode {
+	case CubeOAUTH2ErrCodeOK:
+	case CubeOAUTH2ErrCodeTokenNotFound, CubeOAUTH2ErrCodeBadScope, CubeOAUTH2ErrCodeBadClient:
+		return c.conf.NegativeTTL
+	default:
+		return 0
+	}
+	ttl := time.Duration(r.ExpiresIn) * time.Second
+	if c.conf.MaxTTL > 0 && c.conf.MaxTTL < ttl {
+		ttl = c.conf.MaxTTL
+	}
+	return ttl
+}
+
+// remove removes element from cache. Must be called under lock
+func (c *Cache) remove(e *list.Element) {
+	key := e.Value.(*cacheEntry).key
+	c.lru.Remove(e)
+	scopes := c.entries[key.token]
+	delete(scopes, key.scope)
+	if len(scopes) == 0 {
+		delete(c.entries, key.token)
+	}
+}
@@ -0,0 +1,200 @@
+package oauth2_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Apakhov/cube/cubeapi/oauth2"
+	"github.com/stretchr/testify/require"
+)
+
+// countingValidator counts calls of testValidator, responses expire in
+// expiresIn seconds
+func countingValidator(calls *int32, expiresIn int32) oauth2.Validator {
+	return oauth2.ValidatorFunc(func(ctx context.Context, token, scope string) (*oauth2.ResponseOAUTH2, error) {
+		atomic.AddInt32(calls, 1)
+		if token == "broken" {
+			return nil, errors.New("storage is down")
+		}
+		if scope != "scope" {
+			return oauth2.ErrorResponse(oauth2.CubeOAUTH2ErrCodeBadScope), nil
+		}
+		return &oauth2.ResponseOAUTH2{
+			ReturnCode: oauth2.CubeOAUTH2ErrCodeOK,
+			Username:   token,
+			ExpiresIn:  expiresIn,
+		}, nil
+	})
+}
+
+func TestCacheHit(t *testing.T) {
+	var calls int32
+	c := oauth2.CreateCache(countingValidator(&calls, 3600), oauth2.CacheConfig{})
+
+	for i := 0; i < 3; i++ {
+		r, err := c.Validate(context.Background(), "token", "scope")
+		require.NoError(t, err)
+		require.Equal(t, "token", r.Username)
+		// modifying result doesn't affect cache
+		r.Username = "changed"
+	}
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	require.Equal(t, oauth2.CacheStats{Hits: 2, Misses: 1, Size: 1}, c.Stats())
+}
+
+func TestCacheNotCached(t *testing.T) {
+	var calls int32
+	c := oauth2.CreateCache(countingValidator(&calls, 3600), oauth2.CacheConfig{})
+
+	for i := 0; i < 2; i++ {
+		r, err := c.Validate(context.Background(), "token", "other")
+		require.NoError(t, err)
+		require.Equal(t, oauth2.CubeOAUTH2ErrCodeBadScope, r.ReturnCode)
+		_, err = c.Validate(context.Background(), "broken", "scope")
+		require.Error(t, err)
+	}
+	require.Equal(t, int32(4), atomic.LoadInt32(&calls))
+
+	var expiredCalls int32
+	c = oauth2.CreateCache(countingValidator(&expiredCalls, 0), oauth2.CacheConfig{})
+	for i := 0; i < 2; i++ {
+		_, err := c.Validate(context.Background(), "token", "scope")
+		require.NoError(t, err)
+	}
+	require.Equal(t, int32(2), atomic.LoadInt32(&expiredCalls))
+	require.Equal(t, 0, c.Stats().Size)
+}
+
+func TestCacheMaxTTL(t *testing.T) {
+	var calls int32
+	c := oauth2.CreateCache(countingValidator(&calls, 3600), oauth2.CacheConfig{
+		MaxTTL: 50 * time.Millisecond,
+	})
+
+	_, err := c.Validate(context.Background(), "token", "scope")
+	require.NoError(t, err)
+	_, err = c.Validate(context.Background(), "token", "scope")
+	require.NoError(t, err)
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	time.Sleep(60 * time.Millisecond)
+	_, err = c.Validate(context.Background(), "token", "scope")
+	require.NoError(t, err)
+	require.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestCacheLRU(t *testing.T) {
+	var calls int32
+	c := oauth2.CreateCache(countingValidator(&calls, 3600), oauth2.CacheConfig{MaxSize: 2})
+
+	for _, token := range []string{"a", "b", "a", "c"} {
+		_, err := c.Validate(context.Background(), token, "scope")
+		require.NoError(t, err)
+	}
+	// b is least recently used and evicted
+	require.Equal(t, oauth2.CacheStats{Hits: 1, Misses: 3, Size: 2, Evictions: 1}, c.Stats())
+	_, err := c.Validate(context.Background(), "a", "scope")
+	require.NoError(t, err)
+	_, err = c.Validate(context.Background(), "b", "scope")
+	require.NoError(t, err)
+	require.Equal(t, int32(4), atomic.LoadInt32(&calls))
+}
+
+func TestCacheInvalidate(t *testing.T) {
+	var calls int32
+	c := oauth2.CreateCache(countingValidator(&calls, 3600), oauth2.CacheConfig{})
+
+	_, err := c.Validate(context.Background(), "token", "scope")
+	require.NoError(t, err)
+	_, err = c.Validate(context.Background(), "other", "scope")
+	require.NoError(t, err)
+	c.Invalidate("token")
+	require.Equal(t, 1, c.Stats().Size)
+
+	_, err = c.Validate(context.Background(), "token", "scope")
+	require.NoError(t, err)
+	_, err = c.Validate(context.Background(), "other", "scope")
+	require.NoError(t, err)
+	require.Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
+
+func TestCacheInvalidateInFlight(t *testing.T) {
+	var calls int32
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	v := countingValidator(&calls, 3600)
+	c := oauth2.CreateCache(oauth2.ValidatorFunc(func(ctx context.Context, token, scope string) (*oauth2.ResponseOAUTH2, error) {
+		if atomic.LoadInt32(&calls) == 0 {
+			started <- struct{}{}
+			<-release
+		}
+		return v.Validate(ctx, token, scope)
+	}), oauth2.CacheConfig{})
+
+	done := make(chan error)
+	go func() {
+		_, err := c.Validate(context.Background(), "token", "scope")
+		done <- err
+	}()
+	<-started
+	// token is revoked while validation is in progress
+	c.Invalidate("token")
+	close(release)
+	require.NoError(t, <-done)
+	require.Equal(t, 0, c.Stats().Size)
+
+	_, err := c.Validate(context.Background(), "token", "scope")
+	require.NoError(t, err)
+	require.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	require.Equal(t, 1, c.Stats().Size)
+}
+
+func TestCacheNegative(t *testing.T) {
+	var calls int32
+	v := oauth2.ValidatorFunc(func(ctx context.Context, token, scope string) (*oauth2.ResponseOAUTH2, error) {
+		atomic.AddInt32(&calls, 1)
+		switch token {
+		case "unknown":
+			return oauth2.ErrorResponse(oauth2.CubeOAUTH2ErrCodeTokenNotFound), nil
+		case "badscope":
+			return oauth2.ErrorResponse(oauth2.CubeOAUTH2ErrCodeBadScope), nil
+		case "badclient":
+			return oauth2.ErrorResponse(oauth2.CubeOAUTH2ErrCodeBadClient), nil
+		case "dberror":
+			return oauth2.ErrorResponse(oauth2.CubeOAUTH2ErrCodeDBError), nil
+		}
+		return nil, errors.New("storage is down")
+	})
+	c := oauth2.CreateCache(v, oauth2.CacheConfig{NegativeTTL: 50 * time.Millisecond})
+
+	negative := map[string]int32{
+		"unknown":   oauth2.CubeOAUTH2ErrCodeTokenNotFound,
+		"badscope":  oauth2.CubeOAUTH2ErrCodeBadScope,
+		"badclient": oauth2.CubeOAUTH2ErrCodeBadClient,
+	}
+	for i := 0; i < 2; i++ {
+		for token, code := range negative {
+			r, err := c.Validate(context.Background(), token, "scope")
+			require.NoError(t, err)
+			require.Equal(t, code, r.ReturnCode)
+		}
+	}
+	require.Equal(t, int32(3), atomic.LoadInt32(&calls))
+
+	for i := 0; i < 2; i++ {
+		r, err := c.Validate(context.Background(), "dberror", "scope")
+		require.NoError(t, err)
+		require.Equal(t, oauth2.CubeOAUTH2ErrCodeDBError, r.ReturnCode)
+		_, err = c.Validate(context.Background(), "transport", "scope")
+		require.Error(t, err)
+	}
+	require.Equal(t, int32(7), atomic.LoadInt32(&calls))
+
+	time.Sleep(60 * time.Millisecond)
+	_, err := c.Validate(context.Background(), "unknown", "scope")
+	require.NoError(t, err)
+	require.Equal(t, int32(8), atomic.LoadInt32(&calls))
+}
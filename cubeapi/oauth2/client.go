@@ -0,0 +1,439 @@
+package oauth2
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Apakhov/cube/cubeapi"
+)
+
+// ClientConfig configures Client
+type ClientConfig struct {
+	// Network of cube server, "tcp" if empty
+	Network string
+	// Address of cube server, "host:port" for tcp. It is used if there are
+	// no Endpoints
+	Address string
+	// Endpoints are addresses of cube server replicas, requests are
+	// balanced between them and fail over to other ones on connection
+	// failures
+	Endpoints []string
+	// Resolve makes host names of tcp endpoints resolve to all their
+	// addresses, which are refreshed every ResolveInterval
+	Resolve bool
+	// ResolveInterval is period of resolving, DefaultResolveInterval if zero
+	ResolveInterval time.Duration
+	// ResolveTimeout limits time of resolving, DefaultResolveTimeout if
+	// zero. Resolving isn't limited by context of Validate since it is
+	// shared by all requests
+	ResolveTimeout time.Duration
+	// LookupHost resolves host name to addresses,
+	// net.DefaultResolver.LookupHost if nil
+	LookupHost func(ctx context.Context, host string) ([]string, error)
+	// Balancing chooses endpoint for request
+	Balancing Balancing
+	// EjectAfter is number of consecutive failures ejecting endpoint from
+	// balancing, endpoints aren't ejected if zero. Connection failures,
+	// malformed responses and CubeOAUTH2ErrCodeDBError are failures
+	EjectAfter int
+	// EjectTime is time ejected endpoint is re-admitted after,
+	// DefaultEjectTime if zero. Re-admitted endpoint is ejected again on
+	// the first failure
+	EjectTime time.Duration
+	// Breaker configures circuit breakers of endpoints, Validate fails with
+	// ErrCircuitOpen if breakers of all endpoints are open
+	Breaker BreakerConfig
+	// Hedge configures hedged requests to other endpoints
+	Hedge HedgeConfig
+	// TLS enables TLS with config, server name is host of endpoint if
+	// ServerName of config is empty. See cubeapi.CreateClientTLSConfig
+	TLS *tls.Config
+	// DialTimeout limits time of dialing, context deadline is used if zero
+	DialTimeout time.Duration
+	// KeepAlive is TCP keep-alive period, see net.Dialer
+	KeepAlive time.Duration
+	// MaxOpenConns limits number of open connections to server, unlimited if zero
+	MaxOpenConns int
+	// MaxIdleConns limits number of idle connections kept for reuse,
+	// cubeapi.DefaultMaxIdle if zero
+	MaxIdleConns int
+	// IdleTimeout closes connections staying idle for longer, unlimited if zero
+	IdleTimeout time.Duration
+	// Multiplex enables pipelining of all requests over one connection
+	// instead of pool of connections
+	Multiplex bool
+	// ErrorHandler is called in Multiplex mode for responses with RequestID
+	// no request is waiting for, it can be nil
+	ErrorHandler func(error)
+	// Coalesce makes concurrent validations of the same token and scope
+	// share one request and its result
+	Coalesce bool
+	// ReturnCodeErrors makes Validate return ReturnCodeError instead of
+	// response with return code other than CubeOAUTH2ErrCodeOK
+	ReturnCodeErrors bool
+	// Retry configures retries of transient failures, validation is tried
+	// once if zero
+	Retry RetryPolicy
+}
+
+// Client validates tokens using cube OAUTH2 service. Connections are kept
+// in pool and reused between requests, or in Multiplex mode requests are
+// pipelined over one connection per endpoint
+type Client struct {
+	conf      ClientConfig
+	dialer    *net.Dialer
+	pool      *cubeapi.Pool
+	endpoints *endpointSet
+	muxLock   sync.Mutex
+	muxes     map[string]*cubeapi.MuxConn
+	muxDials  map[string]*muxDial
+	flights   flightGroup
+	hedger    *hedger
+}
+
+// CreateClient creates Client
+func CreateClient(conf ClientConfig) *Client {
+	if conf.Network == "" {
+		conf.Network = "tcp"
+	}
+	dialer := &net.Dialer{
+		Timeout:   conf.DialTimeout,
+		KeepAlive: conf.KeepAlive,
+	}
+	c := &Client{
+		conf:     conf,
+		dialer:   dialer,
+		muxes:    make(map[string]*cubeapi.MuxConn),
+		muxDials: make(map[string]*muxDial),
+		hedger:   createHedger(conf.Hedge),
+	}
+	c.endpoints = createEndpointSet(conf, c.removeEndpoint)
+	c.pool = cubeapi.CreatePool(cubeapi.PoolConfig{
+		Network:     conf.Network,
+		Dial:        c.dial,
+		MaxOpen:     conf.MaxOpenConns,
+		MaxIdle:     conf.MaxIdleConns,
+		IdleTimeout: conf.IdleTimeout,
+	})
+	return c
+}
+
+// Stats returns statistics of connection pool
+func (c *Client) Stats() cubeapi.PoolStats {
+	return c.pool.Stats()
+}
+
+// Endpoints returns state of endpoints
+func (c *Client) Endpoints() []EndpointStats {
+	return c.endpoints.stats()
+}
+
+// Close closes idle connections. Client can't be used after Close
+func (c *Client) Close() error {
+	c.muxLock.Lock()
+	for _, m := range c.muxes {
+		m.Close()
+	}
+	c.muxLock.Unlock()
+	return c.pool.Close()
+}
+
+// removeEndpoint closes connections to address of endpoint that is no
+// longer resolved
+func (c *Client) removeEndpoint(address string) {
+	c.muxLock.Lock()
+	if m, ok := c.muxes[address]; ok {
+		m.Close()
+		delete(c.muxes, address)
+	}
+	c.muxLock.Unlock()
+	c.pool.Drain(address)
+}
+
+// Validate checks token with scope. Context cancellation and deadline
+// interrupt dialing, writing and reading. In Coalesce mode request shared
+// with other callers is interrupted only when all of them are gone.
+// Transient failures are retried according to Retry policy
+func (c *Client) Validate(ctx context.Context, token, scope string) (*ResponseOAUTH2, error) {
+	r, err := c.conf.Retry.retry(ctx, func(ctx context.Context) (*ResponseOAUTH2, error) {
+		if c.conf.Coalesce {
+			key := cacheKey{token: token, scope: scope}
+			return c.flights.do(ctx, key, func(ctx context.Context) (*ResponseOAUTH2, error) {
+				return c.validate(ctx, token, scope)
+			})
+		}
+		return c.validate(ctx, token, scope)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if c.conf.ReturnCodeErrors {
+		if err = ErrorOf(r); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func (c *Client) validate(ctx context.Context, token, scope string) (*ResponseOAUTH2, error) {
+	req, err := CreateOAUTH2Request(token, scope)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if c.hedger != nil {
+		return c.hedger.do(ctx, c, req)
+	}
+	return c.send(ctx, req, &usedEndpoints{})
+}
+
+// send sends request to endpoint not used yet, failing over to other ones
+// on connection failures
+func (c *Client) send(ctx context.Context, req *SendBuffer, used *usedEndpoints) (*ResponseOAUTH2, error) {
+	var lastErr error
+	for {
+		e, err := c.endpoints.pick(ctx, used)
+		if err != nil && lastErr != nil && errors.Is(err, ErrCircuitOpen) {
+			// failed over to endpoints with open breakers
+			return nil, lastErr
+		}
+		if err != nil {
+			return nil, err
+		}
+		if e == nil && lastErr == nil {
+			return nil, ErrNoEndpoints
+		}
+		if e == nil {
+			// all endpoints failed
+			return nil, lastErr
+		}
+		r, err := c.validateAt(ctx, e.addr, req)
+		c.endpoints.done(e, r, err)
+		if err != nil && ctx.Err() == nil && isTransportError(err) {
+			lastErr = err
+			continue
+		}
+		return r, err
+	}
+}
+
+// validateAt sends request to endpoint at address
+func (c *Client) validateAt(ctx context.Context, address string, req *SendBuffer) (*ResponseOAUTH2, error) {
+	if c.conf.Multiplex {
+		return c.validateMux(ctx, address, req)
+	}
+
+	for {
+		conn, err := c.pool.Get(ctx, address)
+		if ctx.Err() != nil {
+			if err == nil {
+				conn.Release(nil)
+			}
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get connection: %w", err)
+		}
+
+		stop := watchContext(ctx, conn)
+		r, err := roundTrip(conn, req)
+		if stop() {
+			conn.Release(contextError(ctx))
+			return nil, contextError(ctx)
+		}
+		conn.SetDeadline(time.Time{})
+		conn.Release(err)
+		if err != nil && conn.Reused() && isTransportError(err) {
+			// idle connection could be closed by server, retrying on new one
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return r, nil
+	}
+}
+
+func (c *Client) validateMux(ctx context.Context, address string, req *SendBuffer) (*ResponseOAUTH2, error) {
+	m, err := c.muxConn(ctx, address)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial: %w", err)
+	}
+	frame, err := m.RoundTrip(ctx, req.Bytes())
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to round trip: %w", err)
+	}
+	return parseResponse(frame)
+}
+
+// muxDial is dialing of multiplexed connection callers to the same
+// address wait for
+type muxDial struct {
+	done chan struct{}
+	m    *cubeapi.MuxConn
+	err  error
+	// abandoned reports whether dialing was interrupted by context of
+	// caller dialing
+	abandoned bool
+}
+
+// muxConn returns alive multiplexed connection to address, dialing new one
+// if needed. Dialing doesn't block requests to other addresses, concurrent
+// callers wait for the same dialing
+func (c *Client) muxConn(ctx context.Context, address string) (*cubeapi.MuxConn, error) {
+	for {
+		c.muxLock.Lock()
+		if m, ok := c.muxes[address]; ok && m.Err() == nil {
+			c.muxLock.Unlock()
+			return m, nil
+		}
+		d, dialing := c.muxDials[address]
+		if !dialing {
+			d = &muxDial{done: make(chan struct{})}
+			c.muxDials[address] = d
+		}
+		c.muxLock.Unlock()
+
+		if !dialing {
+			return c.dialMux(ctx, address, d)
+		}
+		select {
+		case <-d.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if d.err == nil || !d.abandoned {
+			return d.m, d.err
+		}
+		// caller dialing is gone, dialing again
+	}
+}
+
+// dialMux dials multiplexed connection to address and completes d
+func (c *Client) dialMux(ctx context.Context, address string, d *muxDial) (*cubeapi.MuxConn, error) {
+	conn, err := c.dial(ctx, c.conf.Network, address)
+	c.muxLock.Lock()
+	defer c.muxLock.Unlock()
+	delete(c.muxDials, address)
+	defer close(d.done)
+	if err != nil {
+		d.err = err
+		d.abandoned = ctx.Err() != nil
+		return nil, err
+	}
+	if m, ok := c.muxes[address]; ok && m.Err() == nil {
+		// lost race with other dialing
+		conn.Close()
+		d.m = m
+		return m, nil
+	}
+	d.m = cubeapi.CreateMuxConnWithConfig(conn, cubeapi.MuxConfig{
+		OnError: c.conf.ErrorHandler,
+		// TLS connection can't be written after write timeout
+		KeepOnInterruptedWrite: c.conf.TLS == nil,
+	})
+	c.muxes[address] = d.m
+	return d.m, nil
+}
+
+// dial dials address, performing TLS handshake if TLS is enabled
+func (c *Client) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := c.dialer.DialContext(ctx, network, address)
+	if err != nil || c.conf.TLS == nil {
+		return conn, err
+	}
+	conf := c.conf.TLS
+	if conf.ServerName == "" {
+		conf = conf.Clone()
+		conf.ServerName = c.endpoints.serverName(address)
+	}
+	tc := tls.Client(conn, conf)
+	stop := watchContext(ctx, tc)
+	err = tc.Handshake()
+	if stop() {
+		tc.Close()
+		return nil, contextError(ctx)
+	}
+	if err != nil {
+		tc.Close()
+		return nil, fmt.Errorf("failed TLS handshake: %w", err)
+	}
+	tc.SetDeadline(time.Time{})
+	return tc, nil
+}
+
+// watchContext applies context deadline to conn and interrupts conn's I/O
+// when context is done. Returned stop func reports whether context
+// interrupted conn
+func watchContext(ctx context.Context, conn net.Conn) (stop func() bool) {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	done := make(chan struct{})
+	interrupted := make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Unix(1, 0))
+			interrupted <- true
+		case <-done:
+			interrupted <- false
+		}
+	}()
+	return func() bool {
+		close(done)
+		return <-interrupted || contextError(ctx) != nil
+	}
+}
+
+// contextError returns ctx.Err(), reporting context.DeadlineExceeded as soon
+// as deadline is passed since conn deadline may expire before context's one
+func contextError(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+		return context.DeadlineExceeded
+	}
+	return nil
+}
+
+func roundTrip(conn net.Conn, req *SendBuffer) (*ResponseOAUTH2, error) {
+	_, err := conn.Write(req.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to write to connection: %w", err)
+	}
+
+	r := new(ResponseOAUTH2)
+	if err = CreateDecoder(conn).ReadOAUTH2Resp(r); err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	return r, nil
+}
+
+func parseResponse(frame []byte) (*ResponseOAUTH2, error) {
+	r := new(ResponseOAUTH2)
+	if err := CreateDecoder(bytes.NewReader(frame)).ReadOAUTH2Resp(r); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return r, nil
+}
+
+// isTransportError reports whether err is failure of connection rather than
+// malformed response. Connection closed before response is also transport
+// failure
+func isTransportError(err error) bool {
+	c := cubeapi.Cause(err)
+	return c == nil || c == ErrNotEnoughData
+}
@@ -0,0 +1,281 @@
+package oauth2_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Apakhov/cube/cubeapi"
+	"github.com/Apakhov/cube/cubeapi/oauth2"
+	"github.com/stretchr/testify/require"
+)
+
+// serveOnce accepts one connection, reads request and answers with resp
+func serveOnce(t *testing.T, resp []byte, delay time.Duration) (addr string, done <-chan []byte) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	reqs := make(chan []byte, 1)
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		header := make([]byte, 12)
+		if _, err = io.ReadFull(conn, header); err != nil {
+			return
+		}
+		body := make([]byte, binary.LittleEndian.Uint32(header[4:8]))
+		if _, err = io.ReadFull(conn, body); err != nil {
+			return
+		}
+		reqs <- append(header, body...)
+		time.Sleep(delay)
+		conn.Write(resp)
+		io.Copy(ioutil.Discard, conn)
+	}()
+	return l.Addr().String(), reqs
+}
+
+func okResponse() []byte {
+	resp := flat(
+		buildInt32(0x2),
+		buildInt32(0x0),
+		buildInt32(0x0),
+		buildInt32(0x0),
+		buildString("test_client_id"),
+		buildInt32(2002),
+		buildString("testuser@mail.ru"),
+		buildInt32(3600),
+		buildInt64(101010),
+	)
+	binary.LittleEndian.PutUint32(resp[4:8], uint32(len(resp)-12))
+	return resp
+}
+
+func TestClientValidate(t *testing.T) {
+	addr, reqs := serveOnce(t, okResponse(), 0)
+
+	client := oauth2.CreateClient(oauth2.ClientConfig{Address: addr})
+	res, err := client.Validate(context.Background(), "token", "scope")
+	require.NoError(t, err)
+
+	exp := &oauth2.ResponseOAUTH2{
+		ReturnCode: oauth2.CubeOAUTH2ErrCodeOK,
+		CliendID:   "test_client_id",
+		ClientType: 2002,
+		Username:   "testuser@mail.ru",
+		ExpiresIn:  3600,
+		UserID:     101010,
+	}
+	if !reflect.DeepEqual(exp, res) {
+		require.Equal(t, exp, res, "result difference")
+	}
+
+	req, err := oauth2.CreateOAUTH2Request("token", "scope")
+	require.NoError(t, err)
+	require.Equal(t, req.Bytes(), <-reqs)
+}
+
+func TestClientValidateTimeout(t *testing.T) {
+	addr, _ := serveOnce(t, okResponse(), time.Second)
+
+	client := oauth2.CreateClient(oauth2.ClientConfig{Address: addr})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+
+	start := time.Now()
+	_, err := client.Validate(ctx, "token", "scope")
+	require.True(t, errors.Is(err, context.DeadlineExceeded))
+	require.True(t, time.Since(start) < time.Second, "deadline is not honoured")
+}
+
+func TestClientValidateCancel(t *testing.T) {
+	addr, _ := serveOnce(t, okResponse(), time.Second)
+
+	client := oauth2.CreateClient(oauth2.ClientConfig{Address: addr})
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(time.Millisecond * 100)
+		cancel()
+	}()
+
+	start := time.Now()
+	_, err := client.Validate(ctx, "token", "scope")
+	require.True(t, errors.Is(err, context.Canceled))
+	require.True(t, time.Since(start) < time.Second, "cancellation is not honoured")
+}
+
+func TestClientValidateBadResponse(t *testing.T) {
+	resp := okResponse()
+	binary.LittleEndian.PutUint32(resp[0:4], 0x42)
+	addr, _ := serveOnce(t, resp, 0)
+
+	client := oauth2.CreateClient(oauth2.ClientConfig{Address: addr})
+	_, err := client.Validate(context.Background(), "token", "scope")
+	require.True(t, errors.Is(err, oauth2.ErrIncorrectSVCID))
+}
+
+// serveKeepAlive answers every request on every accepted connection with resp
+func serveKeepAlive(t *testing.T, resp []byte) (addr string, accepted *int32, closeFn func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	accepted = new(int32)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(accepted, 1)
+			go func() {
+				defer conn.Close()
+				for {
+					header := make([]byte, 12)
+					if _, err := io.ReadFull(conn, header); err != nil {
+						return
+					}
+					body := make([]byte, binary.LittleEndian.Uint32(header[4:8]))
+					if _, err := io.ReadFull(conn, body); err != nil {
+						return
+					}
+					if _, err := conn.Write(resp); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return l.Addr().String(), accepted, func() { l.Close() }
+}
+
+func TestClientKeepAlive(t *testing.T) {
+	addr, accepted, closeFn := serveKeepAlive(t, okResponse())
+	defer closeFn()
+
+	client := oauth2.CreateClient(oauth2.ClientConfig{Address: addr})
+	defer client.Close()
+	for i := 0; i < 10; i++ {
+		_, err := client.Validate(context.Background(), "token", "scope")
+		require.NoError(t, err)
+	}
+	require.Equal(t, int32(1), atomic.LoadInt32(accepted))
+
+	stats := client.Stats()
+	require.Equal(t, int64(1), stats.Dials)
+	require.Equal(t, int64(9), stats.Reuses)
+	require.Equal(t, 1, stats.Idle)
+}
+
+func TestClientMaxOpenConns(t *testing.T) {
+	addr, accepted, closeFn := serveKeepAlive(t, okResponse())
+	defer closeFn()
+
+	client := oauth2.CreateClient(oauth2.ClientConfig{Address: addr, MaxOpenConns: 2})
+	defer client.Close()
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := client.Validate(context.Background(), "token", "scope")
+			require.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	require.True(t, atomic.LoadInt32(accepted) <= 2, "MaxOpenConns is exceeded")
+}
+
+func TestClientEvictBroken(t *testing.T) {
+	addr, reqs := serveOnce(t, okResponse()[:20], 0)
+
+	client := oauth2.CreateClient(oauth2.ClientConfig{Address: addr})
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	_, err := client.Validate(ctx, "token", "scope")
+	require.Error(t, err)
+	<-reqs
+
+	stats := client.Stats()
+	require.Equal(t, int64(1), stats.Evictions)
+	require.Equal(t, 0, stats.Open)
+}
+
+// serveMultiplexed reads n requests from single connection and answers them
+// in reverse order keeping their request ids
+func serveMultiplexed(t *testing.T, n int) (addr string, accepted *int32) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	accepted = new(int32)
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		atomic.AddInt32(accepted, 1)
+		ids := []int32{}
+		for i := 0; i < n; i++ {
+			frame, err := cubeapi.ReadFrame(conn)
+			if err != nil {
+				return
+			}
+			ids = append(ids, cubeapi.FrameRequestID(frame))
+		}
+		for i := len(ids) - 1; i >= 0; i-- {
+			resp := okResponse()
+			cubeapi.SetFrameRequestID(resp, ids[i])
+			conn.Write(resp)
+		}
+		io.Copy(ioutil.Discard, conn)
+	}()
+	return l.Addr().String(), accepted
+}
+
+func TestClientMultiplex(t *testing.T) {
+	addr, accepted := serveMultiplexed(t, 20)
+
+	client := oauth2.CreateClient(oauth2.ClientConfig{Address: addr, Multiplex: true})
+	defer client.Close()
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := client.Validate(context.Background(), "token", "scope")
+			require.NoError(t, err)
+			require.Equal(t, "testuser@mail.ru", res.Username)
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, int32(1), atomic.LoadInt32(accepted))
+}
+
+func TestClientMultiplexOrphan(t *testing.T) {
+	addr, _ := serveOnce(t, okResponse(), 0)
+
+	errs := make(chan error, 1)
+	client := oauth2.CreateClient(oauth2.ClientConfig{
+		Address:      addr,
+		Multiplex:    true,
+		ErrorHandler: func(err error) { errs <- err },
+	})
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	// serveOnce answers with request id 0 which is never assigned
+	_, err := client.Validate(ctx, "token", "scope")
+	require.True(t, errors.Is(err, context.DeadlineExceeded))
+	require.True(t, errors.Is(<-errs, cubeapi.ErrUnknownRequestID))
+}
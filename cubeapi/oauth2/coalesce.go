@@ -0,0 +1,131 @@
+package oauth2
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// flight is validation shared by concurrent callers
+type flight struct {
+	done    chan struct{}
+	resp    *ResponseOAUTH2
+	err     error
+	waiters int
+	ctx     *flightContext
+}
+
+// flightContext is context of flight, it is done when all callers are gone.
+// Its error is error of context of the last caller, so flight abandoned
+// after deadlines of callers is timeout rather than cancellation. Values
+// are ones of context of caller starting flight
+type flightContext struct {
+	values context.Context
+	done   chan struct{}
+	lock   sync.Mutex
+	err    error
+}
+
+func createFlightContext(values context.Context) *flightContext {
+	return &flightContext{values: values, done: make(chan struct{})}
+}
+
+// Deadline implements context.Context interface, flight has no deadline
+// since callers joining later may have later ones
+func (c *flightContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+// Done implements context.Context interface
+func (c *flightContext) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err implements context.Context interface
+func (c *flightContext) Err() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.err
+}
+
+// Value implements context.Context interface
+func (c *flightContext) Value(key interface{}) interface{} {
+	return c.values.Value(key)
+}
+
+func (c *flightContext) cancel(err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.err == nil {
+		c.err = err
+		close(c.done)
+	}
+}
+
+// flightGroup coalesces concurrent validations of the same token and scope
+// into one request
+type flightGroup struct {
+	lock    sync.Mutex
+	flights map[cacheKey]*flight
+}
+
+// do calls validate once for all concurrent callers with the same key. It
+// is called with context cancelled when all callers are gone, so
+// cancellation of one caller doesn't fail the others
+func (g *flightGroup) do(ctx context.Context, key cacheKey,
+	validate func(ctx context.Context) (*ResponseOAUTH2, error)) (*ResponseOAUTH2, error) {
+	g.lock.Lock()
+	if g.flights == nil {
+		g.flights = make(map[cacheKey]*flight)
+	}
+	f, ok := g.flights[key]
+	if ok {
+		f.waiters++
+	} else {
+		f = &flight{done: make(chan struct{}), waiters: 1, ctx: createFlightContext(ctx)}
+		g.flights[key] = f
+		go g.run(key, f, validate)
+	}
+	g.lock.Unlock()
+
+	select {
+	case <-f.done:
+		if f.err != nil {
+			return nil, f.err
+		}
+		r := *f.resp
+		return &r, nil
+	case <-ctx.Done():
+		g.leave(key, f, ctx.Err())
+		return nil, ctx.Err()
+	}
+}
+
+func (g *flightGroup) run(key cacheKey, f *flight,
+	validate func(ctx context.Context) (*ResponseOAUTH2, error)) {
+	resp, err := validate(f.ctx)
+	g.lock.Lock()
+	if g.flights[key] == f {
+		delete(g.flights, key)
+	}
+	g.lock.Unlock()
+	f.resp, f.err = resp, err
+	f.ctx.cancel(context.Canceled)
+	close(f.done)
+}
+
+// leave removes caller gone with err from flight, cancelling it with err if
+// caller was the last one
+func (g *flightGroup) leave(key cacheKey, f *flight, err error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	f.waiters--
+	if f.waiters > 0 {
+		return
+	}
+	if g.flights[key] == f {
+		// callers coming later start new flight
+		delete(g.flights, key)
+	}
+	f.ctx.cancel(err)
+}
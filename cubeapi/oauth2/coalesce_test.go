@@ -0,0 +1,94 @@
+package oauth2_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Apakhov/cube/cubeapi/oauth2"
+	"github.com/Apakhov/cube/cubeapi/oauth2/oauth2test"
+	"github.com/stretchr/testify/require"
+)
+
+// startSlowServer starts server answering token with scope after delay
+func startSlowServer(delay time.Duration) *oauth2test.Server {
+	s := oauth2test.CreateServer()
+	s.AddToken("token", "scope", oauth2.ResponseOAUTH2{Username: "testuser@mail.ru", ExpiresIn: 3600})
+	s.AddToken("token", "other", oauth2.ResponseOAUTH2{Username: "other@mail.ru", ExpiresIn: 3600})
+	s.SetFaults(oauth2test.Fault{Delay: delay})
+	return s
+}
+
+func TestClientCoalesce(t *testing.T) {
+	s := startSlowServer(100 * time.Millisecond)
+	defer s.Close()
+	conf := s.ClientConfig()
+	conf.Coalesce = true
+	client := oauth2.CreateClient(conf)
+	defer client.Close()
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(scope string) {
+			defer wg.Done()
+			res, err := client.Validate(context.Background(), "token", scope)
+			require.NoError(t, err)
+			require.Equal(t, oauth2.CubeOAUTH2ErrCodeOK, res.ReturnCode)
+			if scope == "scope" {
+				require.Equal(t, "testuser@mail.ru", res.Username)
+			} else {
+				require.Equal(t, "other@mail.ru", res.Username)
+			}
+		}([]string{"scope", "other"}[i%2])
+	}
+	wg.Wait()
+	require.Equal(t, 2, s.Requests())
+
+	// finished request isn't shared with later callers
+	_, err := client.Validate(context.Background(), "token", "scope")
+	require.NoError(t, err)
+	require.Equal(t, 3, s.Requests())
+}
+
+func TestClientCoalesceCancel(t *testing.T) {
+	s := startSlowServer(100 * time.Millisecond)
+	defer s.Close()
+	conf := s.ClientConfig()
+	conf.Coalesce = true
+	client := oauth2.CreateClient(conf)
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	shared := make(chan error, 1)
+	go func() {
+		_, err := client.Validate(context.Background(), "token", "scope")
+		shared <- err
+	}()
+	// caller leaving doesn't interrupt request of others
+	_, err := client.Validate(ctx, "token", "scope")
+	require.True(t, errors.Is(err, context.DeadlineExceeded))
+	require.NoError(t, <-shared)
+	require.Equal(t, 1, s.Requests())
+}
+
+func TestClientCoalesceAllLeave(t *testing.T) {
+	s := startSlowServer(200 * time.Millisecond)
+	defer s.Close()
+	conf := s.ClientConfig()
+	conf.Coalesce = true
+	client := oauth2.CreateClient(conf)
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := client.Validate(ctx, "token", "scope")
+	require.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	// abandoned request is interrupted, its connection is evicted
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, int64(1), client.Stats().Evictions)
+}
@@ -0,0 +1,115 @@
+package oauth2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ReturnCodeError is error of cube answering with return code other than
+// CubeOAUTH2ErrCodeOK. It matches sentinel with the same code in errors.Is
+type ReturnCodeError struct {
+	// Code is return code
+	Code int32
+	// Name is name of code, e.g. CUBE_OAUTH2_ERR_BAD_SCOPE
+	Name string
+	// Description is description of code
+	Description string
+	// ErrorString is error string of response sent by server
+	ErrorString string
+}
+
+// Error implements error interface
+func (e *ReturnCodeError) Error() string {
+	if e.ErrorString == "" || e.ErrorString == e.Description {
+		return fmt.Sprintf("oauth2: %s: %s", e.Name, e.Description)
+	}
+	return fmt.Sprintf("oauth2: %s: %s: %s", e.Name, e.Description, e.ErrorString)
+}
+
+// Is reports whether target is ReturnCodeError with the same code
+func (e *ReturnCodeError) Is(target error) bool {
+	t, ok := target.(*ReturnCodeError)
+	return ok && t.Code == e.Code
+}
+
+// Retryable reports whether request can succeed if repeated, true for
+// CubeOAUTH2ErrCodeDBError only
+func (e *ReturnCodeError) Retryable() bool {
+	return e.Code == CubeOAUTH2ErrCodeDBError
+}
+
+// Response returns error response with code and error string of e
+func (e *ReturnCodeError) Response() *ResponseOAUTH2 {
+	return &ResponseOAUTH2{
+		ReturnCode:  e.Code,
+		ErrorString: e.ErrorString,
+	}
+}
+
+func createReturnCodeError(code int32) *ReturnCodeError {
+	descr, name := errInfoByCode(code)
+	return &ReturnCodeError{
+		Code:        code,
+		Name:        name,
+		Description: descr,
+	}
+}
+
+// sentinels of return codes for errors.Is
+var (
+	// ErrTokenNotFound token not found
+	ErrTokenNotFound = createReturnCodeError(CubeOAUTH2ErrCodeTokenNotFound)
+	// ErrDBError db error
+	ErrDBError = createReturnCodeError(CubeOAUTH2ErrCodeDBError)
+	// ErrUnknownMSG server doesn't support svc message type, unlike
+	// ErrUnknownMSGType it is answered by server
+	ErrUnknownMSG = createReturnCodeError(CubeOAUTH2ErrCodeUnknownMSG)
+	// ErrBadPacket server can't parse request
+	ErrBadPacket = createReturnCodeError(CubeOAUTH2ErrCodeBadPacket)
+	// ErrBadClient bad client
+	ErrBadClient = createReturnCodeError(CubeOAUTH2ErrCodeBadClient)
+	// ErrBadScope bad scope
+	ErrBadScope = createReturnCodeError(CubeOAUTH2ErrCodeBadScope)
+)
+
+// ErrorOf returns ReturnCodeError of response, nil if response is
+// successful
+func ErrorOf(r *ResponseOAUTH2) error {
+	if r.ReturnCode == CubeOAUTH2ErrCodeOK {
+		return nil
+	}
+	e := createReturnCodeError(r.ReturnCode)
+	e.ErrorString = r.ErrorString
+	return e
+}
+
+// ResponseOf returns response of successful validation or error response
+// carried by ReturnCodeError, so validators returning ReturnCodeError can
+// be handled as ones returning error responses. Other errors are returned
+// as is
+func ResponseOf(r *ResponseOAUTH2, err error) (*ResponseOAUTH2, error) {
+	var e *ReturnCodeError
+	if errors.As(err, &e) {
+		return e.Response(), nil
+	}
+	return r, err
+}
+
+// IsRetryable reports whether validation failed with err can succeed if
+// repeated: on retryable ReturnCodeError or failure of connection.
+// Cancelled context, definitive return codes and malformed responses
+// aren't retryable
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var e *ReturnCodeError
+	if errors.As(err, &e) {
+		return e.Retryable()
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	return isTransportError(err)
+}
@@ -0,0 +1,115 @@
+package oauth2_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Apakhov/cube/cubeapi/oauth2"
+	"github.com/Apakhov/cube/cubeapi/oauth2/oauth2test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorOf(t *testing.T) {
+	require.NoError(t, oauth2.ErrorOf(&oauth2.ResponseOAUTH2{ReturnCode: oauth2.CubeOAUTH2ErrCodeOK}))
+
+	err := oauth2.ErrorOf(&oauth2.ResponseOAUTH2{
+		ReturnCode:  oauth2.CubeOAUTH2ErrCodeBadScope,
+		ErrorString: "scope admin is not granted",
+	})
+	require.True(t, errors.Is(err, oauth2.ErrBadScope))
+	require.False(t, errors.Is(err, oauth2.ErrTokenNotFound))
+	require.EqualError(t, err, "oauth2: CUBE_OAUTH2_ERR_BAD_SCOPE: bad scope: scope admin is not granted")
+
+	var rce *oauth2.ReturnCodeError
+	require.True(t, errors.As(err, &rce))
+	require.Equal(t, &oauth2.ReturnCodeError{
+		Code:        oauth2.CubeOAUTH2ErrCodeBadScope,
+		Name:        oauth2.CubeOAUTH2ErrStringBadScope,
+		Description: oauth2.CubeOAUTH2ErrDescrBadScope,
+		ErrorString: "scope admin is not granted",
+	}, rce)
+
+	require.EqualError(t, oauth2.ErrorOf(oauth2.ErrorResponse(oauth2.CubeOAUTH2ErrCodeDBError)),
+		"oauth2: CUBE_OAUTH2_ERR_DB_ERROR: db error")
+}
+
+func TestIsRetryable(t *testing.T) {
+	retryable := map[error]bool{
+		oauth2.ErrDBError:                      true,
+		oauth2.ErrTokenNotFound:                false,
+		oauth2.ErrUnknownMSG:                   false,
+		oauth2.ErrBadPacket:                    false,
+		oauth2.ErrBadClient:                    false,
+		oauth2.ErrBadScope:                     false,
+		oauth2.ErrIncorrectSVCID:               false,
+		oauth2.ErrNotEnoughData:                true,
+		errors.New("connection reset by peer"): true,
+		context.Canceled:                       false,
+		fmt.Errorf("failed to read: %w", context.DeadlineExceeded): false,
+		nil: false,
+	}
+	for err, exp := range retryable {
+		require.Equal(t, exp, oauth2.IsRetryable(err), "%v", err)
+	}
+}
+
+func TestResponseOf(t *testing.T) {
+	r, err := oauth2.ResponseOf(nil, oauth2.ErrorOf(&oauth2.ResponseOAUTH2{
+		ReturnCode:  oauth2.CubeOAUTH2ErrCodeBadClient,
+		ErrorString: "client is banned",
+	}))
+	require.NoError(t, err)
+	require.Equal(t, &oauth2.ResponseOAUTH2{
+		ReturnCode:  oauth2.CubeOAUTH2ErrCodeBadClient,
+		ErrorString: "client is banned",
+	}, r)
+
+	transport := errors.New("connection refused")
+	_, err = oauth2.ResponseOf(nil, transport)
+	require.Equal(t, transport, err)
+}
+
+func TestClientReturnCodeErrors(t *testing.T) {
+	s := oauth2test.CreateServer()
+	defer s.Close()
+	s.AddToken("token", "scope", oauth2.ResponseOAUTH2{Username: "testuser@mail.ru"})
+	conf := s.ClientConfig()
+	conf.ReturnCodeErrors = true
+	client := oauth2.CreateClient(conf)
+	defer client.Close()
+
+	r, err := client.Validate(context.Background(), "token", "scope")
+	require.NoError(t, err)
+	require.Equal(t, "testuser@mail.ru", r.Username)
+
+	r, err = client.Validate(context.Background(), "unknown", "scope")
+	require.Nil(t, r)
+	require.True(t, errors.Is(err, oauth2.ErrTokenNotFound))
+	_, err = client.Validate(context.Background(), "token", "other")
+	require.True(t, errors.Is(err, oauth2.ErrBadScope))
+	require.False(t, oauth2.IsRetryable(err))
+}
+
+func TestCacheReturnCodeErrors(t *testing.T) {
+	var calls int32
+	v := oauth2.ValidatorFunc(func(ctx context.Context, token, scope string) (*oauth2.ResponseOAUTH2, error) {
+		atomic.AddInt32(&calls, 1)
+		if token == "dberror" {
+			return nil, oauth2.ErrDBError
+		}
+		return nil, oauth2.ErrTokenNotFound
+	})
+	c := oauth2.CreateCache(v, oauth2.CacheConfig{NegativeTTL: time.Minute})
+
+	for i := 0; i < 2; i++ {
+		_, err := c.Validate(context.Background(), "unknown", "scope")
+		require.True(t, errors.Is(err, oauth2.ErrTokenNotFound))
+		_, err = c.Validate(context.Background(), "dberror", "scope")
+		require.True(t, errors.Is(err, oauth2.ErrDBError))
+	}
+	require.Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
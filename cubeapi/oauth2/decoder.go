@@ -0,0 +1,139 @@
+package oauth2
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	"github.com/Apakhov/cube/cubeapi"
+)
+
+// Decoder reads oauth2 responses directly from io.Reader
+type Decoder struct {
+	decoder *cubeapi.Decoder
+}
+
+// CreateDecoder creates Decoder
+func CreateDecoder(r io.Reader) *Decoder {
+	return &Decoder{
+		decoder: cubeapi.CreateDecoder(r),
+	}
+}
+
+// ReadOAUTH2Resp reads oauth2 response
+func (d *Decoder) ReadOAUTH2Resp(r *ResponseOAUTH2) error {
+	const op = "read OAUTH2 response"
+	h := &cubeapi.Header{}
+	if err := d.decoder.ReadHeader(h); err != nil {
+		return cubeapi.WithOp(err, op)
+	}
+	if h.SvcID != cubeOAUTH2SvcID {
+		return withOffset(ErrIncorrectSVCID, op, "svc id", 0)
+	}
+	body, err := d.decoder.ReadBody(h)
+	if err != nil {
+		return cubeapi.WithOp(err, op)
+	}
+
+	bodyReader := bytes.NewReader(body)
+	err = readOAUTH2RespBody(createBodyDecoder(bodyReader), r)
+	if errors.Is(err, ErrNotEnoughData) {
+		// body is read completely, so response is shorter than it should be
+		return replaceCause(err, op, ErrIncorrectLen)
+	}
+	if err != nil {
+		return cubeapi.WithOp(err, op)
+	}
+	if bodyReader.Len() > 0 {
+		return withOffset(ErrIncorrectBodyLen, op, "", bodyOffset(body, bodyReader))
+	}
+	return nil
+}
+
+// createBodyDecoder creates decoder of body read separately from header
+func createBodyDecoder(r io.Reader) *cubeapi.Decoder {
+	d := cubeapi.CreateDecoder(r)
+	d.SetOffset(cubeapi.HeaderLen)
+	return d
+}
+
+// bodyOffset returns offset of unread part of body from start of frame
+func bodyOffset(body []byte, r *bytes.Reader) int64 {
+	return int64(cubeapi.HeaderLen + len(body) - r.Len())
+}
+
+func readOAUTH2RespBody(d *cubeapi.Decoder, r *ResponseOAUTH2) error {
+	if err := d.ReadInt32(&r.ReturnCode); err != nil {
+		return cubeapi.WithField(err, "return code")
+	}
+	if r.ReturnCode != CubeOAUTH2ErrCodeOK {
+		return cubeapi.WithField(d.ReadString(&r.ErrorString), "error string")
+	}
+	if err := d.ReadString(&r.CliendID); err != nil {
+		return cubeapi.WithField(err, "client id")
+	}
+	if err := d.ReadInt32(&r.ClientType); err != nil {
+		return cubeapi.WithField(err, "client type")
+	}
+	if err := d.ReadString(&r.Username); err != nil {
+		return cubeapi.WithField(err, "username")
+	}
+	if err := d.ReadInt32(&r.ExpiresIn); err != nil {
+		return cubeapi.WithField(err, "expires in")
+	}
+	if err := d.ReadInt64(&r.UserID); err != nil {
+		return cubeapi.WithField(err, "user id")
+	}
+	return nil
+}
+
+// ReadOAUTH2Request reads oauth2 request. RequestID is set even if request
+// is malformed, so it can be answered. Whole frame is read unless svc id is
+// incorrect
+func (d *Decoder) ReadOAUTH2Request(r *RequestOAUTH2) error {
+	const op = "read OAUTH2 request"
+	h := &cubeapi.Header{}
+	if err := d.decoder.ReadHeader(h); err != nil {
+		return cubeapi.WithOp(err, op)
+	}
+	r.RequestID = h.RequestID
+	if h.SvcID != cubeOAUTH2SvcID {
+		return withOffset(ErrIncorrectSVCID, op, "svc id", 0)
+	}
+	body, err := d.decoder.ReadBody(h)
+	if err != nil {
+		return cubeapi.WithOp(err, op)
+	}
+
+	bodyReader := bytes.NewReader(body)
+	err = readOAUTH2ReqBody(createBodyDecoder(bodyReader), r)
+	if errors.Is(err, ErrNotEnoughData) {
+		// body is read completely, so request is shorter than it should be
+		return replaceCause(err, op, ErrIncorrectLen)
+	}
+	if err != nil {
+		return cubeapi.WithOp(err, op)
+	}
+	if bodyReader.Len() > 0 {
+		return withOffset(ErrIncorrectBodyLen, op, "", bodyOffset(body, bodyReader))
+	}
+	return nil
+}
+
+func readOAUTH2ReqBody(d *cubeapi.Decoder, r *RequestOAUTH2) error {
+	var msg int32
+	offset := d.Offset()
+	if err := d.ReadInt32(&msg); err != nil {
+		return cubeapi.WithField(err, "svc message type")
+	}
+	if msg != cubeOAUTH2SvcMSG {
+		return withOffset(ErrUnknownMSGType, "", "svc message type", offset)
+	}
+	if err := d.ReadString(&r.Token); err != nil {
+		return cubeapi.WithField(err, "token")
+	}
+	if err := d.ReadString(&r.Scope); err != nil {
+		return cubeapi.WithField(err, "scope")
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package oauth2_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/Apakhov/cube/cubeapi"
+	"github.com/Apakhov/cube/cubeapi/oauth2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadOAUTH2Resp(t *testing.T) {
+	exp := oauth2.ResponseOAUTH2{
+		ReturnCode: oauth2.CubeOAUTH2ErrCodeOK,
+		CliendID:   "test_client_id",
+		ClientType: 2002,
+		Username:   "testuser@mail.ru",
+		ExpiresIn:  3600,
+		UserID:     101010,
+	}
+	var res oauth2.ResponseOAUTH2
+	err := oauth2.CreateDecoder(bytes.NewReader(okResponse())).ReadOAUTH2Resp(&res)
+	require.NoError(t, err)
+	if !reflect.DeepEqual(exp, res) {
+		require.Equal(t, exp, res, "result difference")
+	}
+}
+
+func TestReadOAUTH2RespErrCode(t *testing.T) {
+	testBytes := flat(
+		buildInt32(0x2),
+		buildInt32(0x0),
+		buildInt32(0x1),
+		buildInt32(oauth2.CubeOAUTH2ErrCodeBadClient),
+		buildString("lol you died"),
+	)
+	binary.LittleEndian.PutUint32(testBytes[4:8], uint32(len(testBytes)-12))
+
+	exp := oauth2.ResponseOAUTH2{
+		ReturnCode:  oauth2.CubeOAUTH2ErrCodeBadClient,
+		ErrorString: "lol you died",
+	}
+	var res oauth2.ResponseOAUTH2
+	err := oauth2.CreateDecoder(bytes.NewReader(testBytes)).ReadOAUTH2Resp(&res)
+	require.NoError(t, err)
+	if !reflect.DeepEqual(exp, res) {
+		require.Equal(t, exp, res, "result difference")
+	}
+}
+
+func TestReadOAUTH2RespStream(t *testing.T) {
+	stream := append(okResponse(), okResponse()...)
+	d := oauth2.CreateDecoder(bytes.NewReader(stream))
+	for i := 0; i < 2; i++ {
+		var res oauth2.ResponseOAUTH2
+		require.NoError(t, d.ReadOAUTH2Resp(&res), fmt.Sprintf("%d expected no error", i))
+		require.Equal(t, int64(101010), res.UserID)
+	}
+	var res oauth2.ResponseOAUTH2
+	require.True(t, errors.Is(d.ReadOAUTH2Resp(&res), oauth2.ErrNotEnoughData))
+}
+
+func TestReadOAUTH2RespErr(t *testing.T) {
+	for i, c := range parseOAUTH2RespErrCases {
+		testBytes := append([]byte{}, c.bytes...)
+		if c.blCorr {
+			// inserting body length
+			binary.LittleEndian.PutUint32(testBytes[4:8], uint32(len(testBytes)-12))
+		}
+		exp := c.err
+		if c.decErr != nil {
+			exp = c.decErr
+		}
+
+		var res oauth2.ResponseOAUTH2
+		err := oauth2.CreateDecoder(bytes.NewReader(testBytes)).ReadOAUTH2Resp(&res)
+		if err == nil || !errors.Is(err, exp) {
+			errStr := ""
+			if err != nil {
+				errStr = err.Error()
+			}
+			require.Equal(t, exp.Error(), errStr, fmt.Sprintf("%d expected error ", i))
+		}
+	}
+}
+
+func TestReadOAUTH2RespBodyLenErr(t *testing.T) {
+	testBytes := append(okResponse(), 0x1)
+	binary.LittleEndian.PutUint32(testBytes[4:8], uint32(len(testBytes)-12))
+
+	var res oauth2.ResponseOAUTH2
+	err := oauth2.CreateDecoder(bytes.NewReader(testBytes)).ReadOAUTH2Resp(&res)
+	require.True(t, errors.Is(err, oauth2.ErrIncorrectBodyLen))
+}
+
+func TestReadOAUTH2Request(t *testing.T) {
+	req, err := oauth2.CreateOAUTH2Request("token", "scope")
+	require.NoError(t, err)
+	req.SetRequestID(0x7)
+
+	var res oauth2.RequestOAUTH2
+	require.NoError(t, oauth2.CreateDecoder(bytes.NewReader(req.Bytes())).ReadOAUTH2Request(&res))
+	require.Equal(t, oauth2.RequestOAUTH2{RequestID: 0x7, Token: "token", Scope: "scope"}, res)
+}
+
+func TestReadOAUTH2RequestErr(t *testing.T) {
+	for i, c := range parseOAUTH2RequestErrCases {
+		testBytes := append([]byte{}, c.bytes...)
+		if c.blCorr {
+			// inserting body length
+			binary.LittleEndian.PutUint32(testBytes[4:8], uint32(len(testBytes)-12))
+		}
+		var res oauth2.RequestOAUTH2
+		err := oauth2.CreateDecoder(bytes.NewReader(testBytes)).ReadOAUTH2Request(&res)
+		require.True(t, errors.Is(err, c.err), fmt.Sprintf("%d expected error ", i))
+		require.Equal(t, int32(0x7), res.RequestID, fmt.Sprintf("%d expected request id ", i))
+	}
+}
+
+func TestReadOAUTH2ErrorContext(t *testing.T) {
+	err := oauth2.CreateDecoder(bytes.NewReader([]byte{0x2, 0, 0, 0, 0x4})).ReadOAUTH2Resp(&oauth2.ResponseOAUTH2{})
+	require.True(t, errors.Is(err, cubeapi.ErrNotEnoughData))
+	require.True(t, errors.Is(err, oauth2.ErrNotEnoughData))
+
+	// svc message type, token of 1 byte, scope truncated
+	testBytes := []byte{
+		0x2, 0, 0, 0, 0xd, 0, 0, 0, 0x1, 0, 0, 0,
+		0x1, 0, 0, 0,
+		0x1, 0, 0, 0, 't',
+		0x5, 0, 0, 0,
+	}
+	err = oauth2.CreateDecoder(bytes.NewReader(testBytes)).ReadOAUTH2Request(&oauth2.RequestOAUTH2{})
+	require.True(t, errors.Is(err, cubeapi.ErrIncorrectLen))
+	var e *oauth2.Error
+	require.True(t, errors.As(err, &e))
+	require.Equal(t, "scope", e.Field)
+	require.Equal(t, int64(21), e.Offset)
+	require.Equal(t, `read OAUTH2 request "scope" at offset 21 (expected 5 bytes, 0 available): Incorrect length of element`, err.Error())
+}
@@ -0,0 +1,41 @@
+package oauth2
+
+import (
+	"io"
+
+	"github.com/Apakhov/cube/cubeapi"
+)
+
+// Encoder writes oauth2 requests directly to io.Writer
+type Encoder struct {
+	encoder *cubeapi.Encoder
+}
+
+// CreateEncoder creates Encoder
+func CreateEncoder(w io.Writer) *Encoder {
+	return &Encoder{
+		encoder: cubeapi.CreateEncoder(w),
+	}
+}
+
+// WriteOAUTH2Request writes request based on token and scope
+func (e *Encoder) WriteOAUTH2Request(requestID int32, token, scope string) error {
+	err := e.encoder.WriteFrame(cubeOAUTH2SvcID, requestID,
+		cubeapi.Int32(cubeOAUTH2SvcMSG),
+		cubeapi.String(token),
+		cubeapi.String(scope),
+	)
+	if err != nil {
+		return cubeapi.WithOp(err, "write OAUTH2 request")
+	}
+	return nil
+}
+
+// WriteOAUTH2Response writes response to request with requestID
+func (e *Encoder) WriteOAUTH2Response(requestID int32, r *ResponseOAUTH2) error {
+	err := e.encoder.WriteFrame(cubeOAUTH2SvcID, requestID, responseFields(r)...)
+	if err != nil {
+		return cubeapi.WithOp(err, "write OAUTH2 response")
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+package oauth2_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/Apakhov/cube/cubeapi/oauth2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteOAUTH2Request(t *testing.T) {
+	buf, err := oauth2.CreateOAUTH2Request("token", "scope")
+	require.NoError(t, err)
+	buf.SetRequestID(0x7)
+
+	w := &bytes.Buffer{}
+	err = oauth2.CreateEncoder(w).WriteOAUTH2Request(0x7, "token", "scope")
+	require.NoError(t, err)
+	require.Equal(t, buf.Bytes(), w.Bytes())
+}
@@ -0,0 +1,471 @@
+package oauth2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Balancing is strategy of choosing endpoint for request
+type Balancing int
+
+const (
+	// RoundRobin chooses endpoints in turn
+	RoundRobin Balancing = iota
+	// LeastOutstanding chooses endpoint with the least number of requests
+	// in progress
+	LeastOutstanding
+	// TwoChoices chooses endpoint with fewer requests in progress of two
+	// random ones
+	TwoChoices
+)
+
+var balancingNames = map[Balancing]string{
+	RoundRobin:       "round-robin",
+	LeastOutstanding: "least-outstanding",
+	TwoChoices:       "two-choices",
+}
+
+func (b Balancing) String() string {
+	if name, ok := balancingNames[b]; ok {
+		return name
+	}
+	return fmt.Sprintf("Balancing(%d)", int(b))
+}
+
+// ParseBalancing returns Balancing by name, e.g. "least-outstanding"
+func ParseBalancing(name string) (Balancing, error) {
+	for b, n := range balancingNames {
+		if n == name {
+			return b, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown balancing %q", name)
+}
+
+// defaults of endpoints config
+const (
+	DefaultResolveInterval = 30 * time.Second
+	DefaultResolveTimeout  = 5 * time.Second
+	DefaultEjectTime       = 10 * time.Second
+)
+
+// EndpointStats represents state of endpoint
+type EndpointStats struct {
+	// Address of endpoint
+	Address string
+	// Outstanding is number of requests in progress
+	Outstanding int
+	// Fails is number of consecutive failures
+	Fails int
+	// Ejected reports whether endpoint is excluded from balancing
+	Ejected bool
+	// Breaker is state of circuit breaker
+	Breaker BreakerState
+}
+
+// endpoint is cube server replica
+type endpoint struct {
+	// name is configured address endpoint is resolved from
+	name         string
+	addr         string
+	outstanding  int
+	fails        int
+	ejectedUntil time.Time
+	breaker      *breaker
+}
+
+// ejected reports whether endpoint is excluded from balancing at now
+func (e *endpoint) ejected(now time.Time) bool {
+	return now.Before(e.ejectedUntil)
+}
+
+// endpointSet balances requests between endpoints and tracks their health
+type endpointSet struct {
+	conf  ClientConfig
+	names []string
+	// removed is called with addresses of endpoints dropped by resolving
+	removed    func(address string)
+	lock       sync.Mutex
+	endpoints  []*endpoint
+	resolvedAt time.Time
+	// resolving is closed when resolving in progress is done, nil if
+	// there is no resolving
+	resolving  chan struct{}
+	resolveErr error
+	next       int
+	rand       *rand.Rand
+}
+
+// createEndpointSet creates endpointSet of conf.Endpoints or conf.Address
+func createEndpointSet(conf ClientConfig, removed func(address string)) *endpointSet {
+	names := conf.Endpoints
+	if len(names) == 0 {
+		names = []string{conf.Address}
+	}
+	if conf.ResolveInterval <= 0 {
+		conf.ResolveInterval = DefaultResolveInterval
+	}
+	if conf.ResolveTimeout <= 0 {
+		conf.ResolveTimeout = DefaultResolveTimeout
+	}
+	if conf.EjectTime <= 0 {
+		conf.EjectTime = DefaultEjectTime
+	}
+	if conf.LookupHost == nil {
+		conf.LookupHost = net.DefaultResolver.LookupHost
+	}
+	conf.Breaker = withBreakerDefaults(conf.Breaker)
+	// only host names of tcp addresses are resolved
+	conf.Resolve = conf.Resolve && strings.HasPrefix(conf.Network, "tcp")
+	s := &endpointSet{
+		conf:    conf,
+		names:   names,
+		removed: removed,
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	if !conf.Resolve {
+		for _, name := range names {
+			s.endpoints = append(s.endpoints, s.createEndpoint(name, name))
+		}
+	}
+	return s
+}
+
+// createEndpoint creates endpoint at addr resolved from name
+func (s *endpointSet) createEndpoint(name, addr string) *endpoint {
+	return &endpoint{
+		name:    name,
+		addr:    addr,
+		breaker: createBreaker(&s.conf.Breaker),
+	}
+}
+
+// resolve refreshes addresses of endpoints once in ResolveInterval in
+// background, callers wait for resolving only if there are no endpoints
+// yet. Lookups are limited by ResolveTimeout instead of ctx, which limits
+// waiting only
+func (s *endpointSet) resolve(ctx context.Context) error {
+	if !s.conf.Resolve {
+		return nil
+	}
+	s.lock.Lock()
+	if !s.resolvedAt.IsZero() && time.Since(s.resolvedAt) < s.conf.ResolveInterval {
+		s.lock.Unlock()
+		return nil
+	}
+	if s.resolving == nil {
+		s.resolving = make(chan struct{})
+		go s.refresh(s.resolving)
+	}
+	done := s.resolving
+	stale := len(s.endpoints) > 0
+	s.lock.Unlock()
+	if stale {
+		// stale endpoints are used until resolving is done
+		return nil
+	}
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.endpoints) == 0 {
+		return s.resolveErr
+	}
+	return nil
+}
+
+// refresh resolves names of endpoints and closes done. Name failed to
+// resolve keeps its addresses, connections to dropped addresses are closed
+func (s *endpointSet) refresh(done chan struct{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.conf.ResolveTimeout)
+	defer cancel()
+	resolved := make(map[string][]string, len(s.names))
+	var lastErr error
+	for _, name := range s.names {
+		addrs, err := s.lookup(ctx, name)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resolved[name] = addrs
+	}
+
+	s.lock.Lock()
+	old := make(map[string]*endpoint, len(s.endpoints))
+	for _, e := range s.endpoints {
+		old[e.addr] = e
+	}
+	var endpoints []*endpoint
+	for _, name := range s.names {
+		addrs, ok := resolved[name]
+		if !ok {
+			for _, e := range s.endpoints {
+				if e.name == name {
+					delete(old, e.addr)
+					endpoints = append(endpoints, e)
+				}
+			}
+			continue
+		}
+		for _, addr := range addrs {
+			e, ok := old[addr]
+			if !ok {
+				e = s.createEndpoint(name, addr)
+			}
+			delete(old, addr)
+			endpoints = append(endpoints, e)
+		}
+	}
+	s.endpoints = endpoints
+	s.resolveErr = nil
+	if len(endpoints) > 0 {
+		s.resolvedAt = time.Now()
+	} else {
+		s.resolveErr = fmt.Errorf("failed to resolve endpoints: %w", lastErr)
+	}
+	s.resolving = nil
+	close(done)
+	s.lock.Unlock()
+
+	for addr := range old {
+		s.removed(addr)
+	}
+}
+
+// lookup resolves host name of address to addresses with the same port,
+// addresses having ip are returned as is
+func (s *endpointSet) lookup(ctx context.Context, address string) ([]string, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if net.ParseIP(host) != nil {
+		return []string{address}, nil
+	}
+	ips, err := s.conf.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]string, len(ips))
+	for i, ip := range ips {
+		addrs[i] = net.JoinHostPort(ip, port)
+	}
+	return addrs, nil
+}
+
+// serverName returns host of configured address endpoint at address is
+// resolved from, it is name TLS certificate of endpoint is verified with
+func (s *endpointSet) serverName(address string) string {
+	name := address
+	s.lock.Lock()
+	for _, e := range s.endpoints {
+		if e.addr == address {
+			name = e.name
+			break
+		}
+	}
+	s.lock.Unlock()
+	host, _, err := net.SplitHostPort(name)
+	if err != nil {
+		return name
+	}
+	return host
+}
+
+// usedEndpoints is set of endpoints requests of validation were sent to,
+// it is shared by hedged requests
+type usedEndpoints struct {
+	lock sync.Mutex
+	set  map[*endpoint]bool
+}
+
+func (u *usedEndpoints) has(e *endpoint) bool {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	return u.set[e]
+}
+
+func (u *usedEndpoints) add(e *endpoint) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	if u.set == nil {
+		u.set = make(map[*endpoint]bool)
+	}
+	u.set[e] = true
+}
+
+// pick chooses endpoint not used by validation yet and marks it used, nil
+// if all endpoints are used. Ejected endpoints are chosen only if all
+// others are used, endpoints with open breaker aren't chosen. Endpoint
+// must be returned with done
+func (s *endpointSet) pick(ctx context.Context, used *usedEndpoints) (*endpoint, error) {
+	if err := s.resolve(ctx); err != nil {
+		return nil, err
+	}
+	s.lock.Lock()
+	e, change, err := s.pickLocked(used)
+	s.lock.Unlock()
+	s.notify(change)
+	return e, err
+}
+
+func (s *endpointSet) pickLocked(used *usedEndpoints) (*endpoint, *stateChange, error) {
+	if len(s.endpoints) == 0 {
+		return nil, nil, ErrNoEndpoints
+	}
+
+	now := time.Now()
+	var healthy, ejected []*endpoint
+	open := false
+	for _, e := range s.rotation() {
+		if used.has(e) {
+			continue
+		}
+		if !e.breaker.permits(now) {
+			open = true
+			continue
+		}
+		if !e.ejected(now) && !e.ejectedUntil.IsZero() {
+			// re-admitted on probation, the next failure ejects it again
+			e.ejectedUntil = time.Time{}
+			if s.conf.EjectAfter > 0 {
+				e.fails = s.conf.EjectAfter - 1
+			}
+		}
+		if e.ejected(now) {
+			ejected = append(ejected, e)
+		} else {
+			healthy = append(healthy, e)
+		}
+	}
+	candidates := healthy
+	if len(candidates) == 0 {
+		candidates = ejected
+	}
+	if len(candidates) == 0 {
+		if open {
+			return nil, nil, ErrCircuitOpen
+		}
+		return nil, nil, nil
+	}
+
+	e := s.choose(candidates)
+	e.outstanding++
+	used.add(e)
+	for i := range s.endpoints {
+		if s.endpoints[i] == e {
+			s.next = i + 1
+		}
+	}
+	from, changed := e.breaker.acquire(now)
+	if !changed {
+		return e, nil, nil
+	}
+	return e, &stateChange{address: e.addr, from: from, to: e.breaker.currentState()}, nil
+}
+
+// rotation returns endpoints starting from the next one in turn
+func (s *endpointSet) rotation() []*endpoint {
+	n := len(s.endpoints)
+	start := s.next % n
+	r := make([]*endpoint, 0, n)
+	r = append(r, s.endpoints[start:]...)
+	return append(r, s.endpoints[:start]...)
+}
+
+// choose chooses one of candidates listed in turn by Balancing
+func (s *endpointSet) choose(candidates []*endpoint) *endpoint {
+	switch s.conf.Balancing {
+	case LeastOutstanding:
+		best := candidates[0]
+		for _, e := range candidates[1:] {
+			if e.outstanding < best.outstanding {
+				best = e
+			}
+		}
+		return best
+	case TwoChoices:
+		if len(candidates) == 1 {
+			return candidates[0]
+		}
+		i := s.rand.Intn(len(candidates))
+		j := s.rand.Intn(len(candidates) - 1)
+		if j >= i {
+			j++
+		}
+		if candidates[j].outstanding < candidates[i].outstanding {
+			return candidates[j]
+		}
+		return candidates[i]
+	}
+	return candidates[0]
+}
+
+// done returns endpoint picked for request answered with r and err.
+// Endpoint failed EjectAfter times in a row is ejected for EjectTime
+func (s *endpointSet) done(e *endpoint, r *ResponseOAUTH2, err error) {
+	cancelled := errors.Is(err, context.Canceled)
+	timeout := errors.Is(err, context.DeadlineExceeded)
+	failed := !cancelled && !timeout && (err != nil || r.ReturnCode == CubeOAUTH2ErrCodeDBError)
+
+	s.lock.Lock()
+	e.outstanding--
+	now := time.Now()
+	switch {
+	case failed:
+		e.fails++
+		if s.conf.EjectAfter > 0 && e.fails >= s.conf.EjectAfter && !e.ejected(now) {
+			e.ejectedUntil = now.Add(s.conf.EjectTime)
+		}
+	case !cancelled && !timeout:
+		e.fails = 0
+	}
+	var change *stateChange
+	if from, changed := e.breaker.record(now, failed || timeout, cancelled); changed {
+		change = &stateChange{address: e.addr, from: from, to: e.breaker.currentState()}
+	}
+	s.lock.Unlock()
+	s.notify(change)
+}
+
+// notify calls OnStateChange callback on change of breaker state
+func (s *endpointSet) notify(change *stateChange) {
+	if change != nil && s.conf.Breaker.OnStateChange != nil {
+		s.conf.Breaker.OnStateChange(change.address, change.from, change.to)
+	}
+}
+
+// count returns number of endpoints
+func (s *endpointSet) count() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.endpoints)
+}
+
+// stats returns state of endpoints
+func (s *endpointSet) stats() []EndpointStats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	stats := make([]EndpointStats, len(s.endpoints))
+	for i, e := range s.endpoints {
+		stats[i] = EndpointStats{
+			Address:     e.addr,
+			Outstanding: e.outstanding,
+			Fails:       e.fails,
+			Ejected:     e.ejected(now),
+			Breaker:     e.breaker.currentState(),
+		}
+	}
+	return stats
+}
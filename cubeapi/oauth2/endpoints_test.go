@@ -0,0 +1,295 @@
+package oauth2_test
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Apakhov/cube/cubeapi/oauth2"
+	"github.com/Apakhov/cube/cubeapi/oauth2/oauth2test"
+	"github.com/stretchr/testify/require"
+)
+
+// startReplicas starts n servers answering the same tokens
+func startReplicas(n int) ([]*oauth2test.Server, []string) {
+	servers := make([]*oauth2test.Server, n)
+	addrs := make([]string, n)
+	for i := range servers {
+		servers[i] = oauth2test.CreateServer()
+		servers[i].AddToken("token", "scope", oauth2.ResponseOAUTH2{Username: "testuser@mail.ru"})
+		addrs[i] = servers[i].Addr()
+	}
+	return servers, addrs
+}
+
+func closeReplicas(servers []*oauth2test.Server) {
+	for _, s := range servers {
+		s.Close()
+	}
+}
+
+// deadAddr returns address refusing connections
+func deadAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+func TestClientRoundRobin(t *testing.T) {
+	for _, multiplex := range []bool{false, true} {
+		servers, addrs := startReplicas(3)
+		client := oauth2.CreateClient(oauth2.ClientConfig{Endpoints: addrs, Multiplex: multiplex})
+
+		for i := 0; i < 6; i++ {
+			r, err := client.Validate(context.Background(), "token", "scope")
+			require.NoError(t, err)
+			require.Equal(t, "testuser@mail.ru", r.Username)
+		}
+		for _, s := range servers {
+			require.Equal(t, 2, s.Requests(), "multiplex %v", multiplex)
+		}
+		client.Close()
+		closeReplicas(servers)
+	}
+}
+
+func TestClientFailover(t *testing.T) {
+	servers, addrs := startReplicas(1)
+	defer closeReplicas(servers)
+	dead := deadAddr(t)
+	client := oauth2.CreateClient(oauth2.ClientConfig{
+		Endpoints:  []string{dead, addrs[0]},
+		EjectAfter: 2,
+		EjectTime:  100 * time.Millisecond,
+	})
+	defer client.Close()
+
+	for i := 0; i < 4; i++ {
+		_, err := client.Validate(context.Background(), "token", "scope")
+		require.NoError(t, err)
+	}
+	require.Equal(t, 4, servers[0].Requests())
+	stats := client.Endpoints()
+	require.Equal(t, oauth2.EndpointStats{Address: dead, Fails: 2, Ejected: true}, stats[0])
+	require.Equal(t, oauth2.EndpointStats{Address: addrs[0]}, stats[1])
+
+	// re-admitted endpoint is ejected again on the first failure
+	time.Sleep(150 * time.Millisecond)
+	require.False(t, client.Endpoints()[0].Ejected)
+	for i := 0; i < 2; i++ {
+		_, err := client.Validate(context.Background(), "token", "scope")
+		require.NoError(t, err)
+	}
+	require.Equal(t, oauth2.EndpointStats{Address: dead, Fails: 2, Ejected: true}, client.Endpoints()[0])
+
+	// all endpoints failing
+	servers[0].Close()
+	_, err := client.Validate(context.Background(), "token", "scope")
+	require.Error(t, err)
+}
+
+func TestClientBalancingLoad(t *testing.T) {
+	for _, balancing := range []oauth2.Balancing{oauth2.LeastOutstanding, oauth2.TwoChoices} {
+		servers, addrs := startReplicas(2)
+		servers[0].SetFaults(oauth2test.Fault{Delay: 300 * time.Millisecond})
+		servers[1].SetFaults(oauth2test.Fault{Delay: 300 * time.Millisecond})
+		client := oauth2.CreateClient(oauth2.ClientConfig{Endpoints: addrs, Balancing: balancing})
+
+		done := make(chan error)
+		go func() {
+			_, err := client.Validate(context.Background(), "token", "scope")
+			done <- err
+		}()
+		time.Sleep(50 * time.Millisecond)
+		busy := 0
+		if client.Endpoints()[1].Outstanding == 1 {
+			busy = 1
+		}
+		servers[1-busy].SetFaults()
+		for i := 0; i < 3; i++ {
+			_, err := client.Validate(context.Background(), "token", "scope")
+			require.NoError(t, err)
+		}
+		require.Equal(t, 3, servers[1-busy].Requests(), balancing.String())
+		require.NoError(t, <-done)
+		require.Equal(t, 1, servers[busy].Requests(), balancing.String())
+
+		client.Close()
+		closeReplicas(servers)
+	}
+}
+
+func TestClientResolve(t *testing.T) {
+	servers, addrs := startReplicas(1)
+	defer closeReplicas(servers)
+	_, port, err := net.SplitHostPort(addrs[0])
+	require.NoError(t, err)
+	client := oauth2.CreateClient(oauth2.ClientConfig{
+		Endpoints: []string{net.JoinHostPort("localhost", port)},
+		Resolve:   true,
+	})
+	defer client.Close()
+
+	_, err = client.Validate(context.Background(), "token", "scope")
+	require.NoError(t, err)
+	resolved := false
+	for _, e := range client.Endpoints() {
+		resolved = resolved || e.Address == addrs[0]
+	}
+	require.True(t, resolved)
+
+	client = oauth2.CreateClient(oauth2.ClientConfig{
+		Endpoints: []string{"cube.invalid:3333"},
+		Resolve:   true,
+	})
+	defer client.Close()
+	_, err = client.Validate(context.Background(), "token", "scope")
+	require.Error(t, err)
+}
+
+// waitResolved waits for client to resolve endpoints to addr, resolving is
+// started by validations
+func waitResolved(t *testing.T, client *oauth2.Client, addr string) {
+	for i := 0; i < 100; i++ {
+		client.Validate(context.Background(), "token", "scope")
+		if e := client.Endpoints(); len(e) == 1 && e[0].Address == addr {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("endpoints aren't resolved to %s: %v", addr, client.Endpoints())
+}
+
+func TestClientResolveDropped(t *testing.T) {
+	l1, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	_, port, err := net.SplitHostPort(l1.Addr().String())
+	require.NoError(t, err)
+	l2, err := net.Listen("tcp", net.JoinHostPort("127.0.0.2", port))
+	if err != nil {
+		l1.Close()
+		t.Skip("can't listen on 127.0.0.2:", err)
+	}
+	servers := []*oauth2test.Server{oauth2test.CreateServerWithListener(l1), oauth2test.CreateServerWithListener(l2)}
+	defer closeReplicas(servers)
+	for _, s := range servers {
+		s.AddToken("token", "scope", oauth2.ResponseOAUTH2{Username: "testuser@mail.ru"})
+	}
+
+	var lock sync.Mutex
+	var ips []string
+	lookup := func(ctx context.Context, host string) ([]string, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		return ips, nil
+	}
+	setIPs := func(v ...string) {
+		lock.Lock()
+		ips = v
+		lock.Unlock()
+	}
+
+	for _, multiplex := range []bool{false, true} {
+		setIPs("127.0.0.1")
+		client := oauth2.CreateClient(oauth2.ClientConfig{
+			Endpoints:       []string{net.JoinHostPort("cube.test", port)},
+			Resolve:         true,
+			ResolveInterval: time.Nanosecond,
+			LookupHost:      lookup,
+			Multiplex:       multiplex,
+		})
+		_, err = client.Validate(context.Background(), "token", "scope")
+		require.NoError(t, err)
+		require.Equal(t, 1, servers[0].Conns())
+
+		// connections to dropped address are closed
+		setIPs("127.0.0.2")
+		waitResolved(t, client, net.JoinHostPort("127.0.0.2", port))
+		_, err = client.Validate(context.Background(), "token", "scope")
+		require.NoError(t, err)
+		for i := 0; i < 100 && servers[0].Conns() > 0; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		require.Equal(t, 0, servers[0].Conns(), "multiplex %v", multiplex)
+		require.Equal(t, 1, servers[1].Conns(), "multiplex %v", multiplex)
+		client.Close()
+		for i := 0; i < 100 && servers[1].Conns() > 0; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func TestClientResolveSlow(t *testing.T) {
+	servers, addrs := startReplicas(1)
+	defer closeReplicas(servers)
+	_, port, err := net.SplitHostPort(addrs[0])
+	require.NoError(t, err)
+
+	var lock sync.Mutex
+	lookups := 0
+	release := make(chan struct{})
+	lookup := func(ctx context.Context, host string) ([]string, error) {
+		lock.Lock()
+		lookups++
+		wait := release
+		lock.Unlock()
+		select {
+		case <-wait:
+			return []string{"127.0.0.1"}, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	client := oauth2.CreateClient(oauth2.ClientConfig{
+		Endpoints:       []string{net.JoinHostPort("cube.test", port)},
+		Resolve:         true,
+		ResolveInterval: 50 * time.Millisecond,
+		LookupHost:      lookup,
+	})
+	defer client.Close()
+
+	// callers wait for resolving no longer than their contexts, which don't
+	// interrupt resolving
+	for _, timeout := range []time.Duration{10 * time.Millisecond, 50 * time.Millisecond} {
+		start := time.Now()
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		_, err = client.Validate(ctx, "token", "scope")
+		cancel()
+		require.Equal(t, context.DeadlineExceeded, err)
+		require.True(t, time.Since(start) < timeout+100*time.Millisecond, time.Since(start).String())
+	}
+	close(release)
+	_, err = client.Validate(context.Background(), "token", "scope")
+	require.NoError(t, err)
+	lock.Lock()
+	require.Equal(t, 1, lookups)
+	release = make(chan struct{})
+	lock.Unlock()
+
+	// stale endpoints are used while resolving
+	time.Sleep(60 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		_, err = client.Validate(ctx, "token", "scope")
+		cancel()
+		require.NoError(t, err)
+	}
+	lock.Lock()
+	require.Equal(t, 2, lookups)
+	close(release)
+	lock.Unlock()
+}
+
+func TestParseBalancing(t *testing.T) {
+	for _, b := range []oauth2.Balancing{oauth2.RoundRobin, oauth2.LeastOutstanding, oauth2.TwoChoices} {
+		parsed, err := oauth2.ParseBalancing(b.String())
+		require.NoError(t, err)
+		require.Equal(t, b, parsed)
+	}
+	_, err := oauth2.ParseBalancing("random")
+	require.Error(t, err)
+}
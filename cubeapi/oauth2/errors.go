@@ -1,81 +1,63 @@
 package oauth2
 
 import (
+	"errors"
+
 	"github.com/Apakhov/cube/cubeapi"
-	"github.com/pkg/errors"
 )
 
-// Error errors
-type Error struct {
-	msg string
-}
-
-// Error imlements error interface
-func (e *Error) Error() string {
-	return e.msg
-}
+// Error is protocol error. It is cubeapi.Error, so errors of both packages
+// match the same sentinels with errors.Is
+type Error = cubeapi.Error
 
 var (
 	// ErrNotEnoughData not enough data to parse
-	ErrNotEnoughData = &Error{
-		msg: "oauth2: Not enough data",
-	}
+	ErrNotEnoughData = cubeapi.ErrNotEnoughData
 	// ErrIncorrectData can't parse due to incorrect data
-	ErrIncorrectData = &Error{
-		msg: "oauth2: Incorrect data",
-	}
+	ErrIncorrectData = cubeapi.ErrIncorrectData
 	// ErrStringTooLong string is too long to write
-	ErrStringTooLong = &Error{
-		msg: "oauth2: String is too long",
-	}
+	ErrStringTooLong = cubeapi.ErrStringTooLong
 	// ErrBadWritingPos can't write on this position
-	ErrBadWritingPos = &Error{
-		msg: "oauth2: Can't write to this position",
-	}
+	ErrBadWritingPos = cubeapi.ErrBadWritingPos
 	// ErrIncorrectBodyLen incorrect body length
-	ErrIncorrectBodyLen = &Error{
-		msg: "oauth2: Incorrect body length",
-	}
+	ErrIncorrectBodyLen = cubeapi.ErrIncorrectBodyLen
 	// ErrIncorrectLen incorrect length of element
-	ErrIncorrectLen = &Error{
-		msg: "oauth2: Incorrect length of element",
-	}
+	ErrIncorrectLen = cubeapi.ErrIncorrectLen
 	// ErrIncorrectSVCID incorrect svc id
-	ErrIncorrectSVCID = &Error{
-		msg: "oauth2: Incorrect svc id",
-	}
+	ErrIncorrectSVCID = cubeapi.ErrIncorrectSVCID
+	// ErrUnknownMSGType unknown svc message type, answered with
+	// CubeOAUTH2ErrCodeUnknownMSG by server
+	ErrUnknownMSGType = cubeapi.CreateError("Unknown svc message type")
+	// ErrNoEndpoints no endpoints to send request to
+	ErrNoEndpoints = cubeapi.CreateError("No endpoints")
+	// ErrCircuitOpen circuit breakers of all endpoints are open
+	ErrCircuitOpen = cubeapi.CreateError("Circuit breaker is open")
 	// ErrUndefined error is not supported
-	ErrUndefined = &Error{
-		msg: "oauth2: error is not supported",
-	}
+	//
+	// Deprecated: errors of cubeapi aren't translated anymore, so it is
+	// never returned
+	ErrUndefined = cubeapi.CreateError("error is not supported")
 )
 
-func switchError(e error) *Error {
-	switch c := errors.Cause(e).(type) {
-	case *cubeapi.Error:
-		switch c {
-		case cubeapi.ErrNotEnoughData:
-			return ErrNotEnoughData
-		case cubeapi.ErrIncorrectData:
-			return ErrIncorrectData
-		case cubeapi.ErrStringTooLong:
-			return ErrStringTooLong
-		case cubeapi.ErrBadWritingPos:
-			return ErrBadWritingPos
-		case cubeapi.ErrIncorrectBodyLen:
-			return ErrIncorrectBodyLen
-		case cubeapi.ErrIncorrectLen:
-			return ErrIncorrectLen
-		case cubeapi.ErrIncorrectSVCID:
-			return ErrIncorrectSVCID
-		default:
-			return ErrUndefined
-		}
-	case *Error:
-		return c
-	default:
-		return &Error{
-			msg: c.Error(),
-		}
+// withOffset returns err of op on field at offset, offset is negative if
+// unknown
+func withOffset(err error, op, field string, offset int64) *Error {
+	return &Error{
+		Op:     op,
+		Field:  field,
+		Offset: offset,
+		Err:    err,
 	}
 }
+
+// replaceCause returns protocol error of op having context of err, but
+// wrapping sentinel
+func replaceCause(err error, op string, sentinel *Error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		c := *e
+		c.Op, c.Err = op, sentinel
+		return &c
+	}
+	return withOffset(sentinel, op, "", -1)
+}
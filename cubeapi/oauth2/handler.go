@@ -0,0 +1,58 @@
+package oauth2
+
+import (
+	"bytes"
+	"context"
+	"errors"
+
+	"github.com/Apakhov/cube/cubeapi"
+)
+
+// Validator validates token with scope
+type Validator interface {
+	Validate(ctx context.Context, token, scope string) (*ResponseOAUTH2, error)
+}
+
+// ValidatorFunc adapts function to Validator
+type ValidatorFunc func(ctx context.Context, token, scope string) (*ResponseOAUTH2, error)
+
+// Validate implements Validator interface
+func (f ValidatorFunc) Validate(ctx context.Context, token, scope string) (*ResponseOAUTH2, error) {
+	return f(ctx, token, scope)
+}
+
+var (
+	_ Validator = (*Client)(nil)
+	_ Validator = (*Cache)(nil)
+)
+
+// handler answers OAUTH2 requests using Validator
+type handler struct {
+	v Validator
+}
+
+// CreateHandler creates cubeapi.Handler of OAUTH2 service answering with v,
+// it is registered with SvcID. Malformed requests are answered with
+// CubeOAUTH2ErrCodeBadPacket, errors of v with CubeOAUTH2ErrCodeDBError
+func CreateHandler(v Validator) cubeapi.Handler {
+	return &handler{v: v}
+}
+
+// ServeCube implements cubeapi.Handler interface
+func (h *handler) ServeCube(ctx context.Context, req *cubeapi.Request) ([]cubeapi.Field, error) {
+	r := &RequestOAUTH2{RequestID: req.Header.RequestID}
+	bodyReader := bytes.NewReader(req.Body)
+	err := readOAUTH2ReqBody(createBodyDecoder(bodyReader), r)
+	switch {
+	case errors.Is(err, ErrUnknownMSGType):
+		return responseFields(ErrorResponse(CubeOAUTH2ErrCodeUnknownMSG)), nil
+	case err != nil, bodyReader.Len() > 0:
+		return responseFields(ErrorResponse(CubeOAUTH2ErrCodeBadPacket)), nil
+	}
+
+	resp, err := ResponseOf(h.v.Validate(ctx, r.Token, r.Scope))
+	if err != nil {
+		return responseFields(ErrorResponse(CubeOAUTH2ErrCodeDBError)), nil
+	}
+	return responseFields(resp), nil
+}
@@ -0,0 +1,94 @@
+package oauth2_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/Apakhov/cube/cubeapi"
+	"github.com/Apakhov/cube/cubeapi/oauth2"
+	"github.com/stretchr/testify/require"
+)
+
+// startHandler starts cubeapi.Server serving OAUTH2 with v
+func startHandler(t *testing.T, v oauth2.Validator) (*cubeapi.Server, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := cubeapi.CreateServer()
+	s.Handle(oauth2.SvcID, oauth2.CreateHandler(v))
+	go s.Serve(l)
+	return s, l.Addr().String()
+}
+
+var testValidator = oauth2.ValidatorFunc(func(ctx context.Context, token, scope string) (*oauth2.ResponseOAUTH2, error) {
+	switch {
+	case token == "broken":
+		return nil, errors.New("storage is down")
+	case token != "token":
+		return oauth2.ErrorResponse(oauth2.CubeOAUTH2ErrCodeTokenNotFound), nil
+	case scope != "scope":
+		return oauth2.ErrorResponse(oauth2.CubeOAUTH2ErrCodeBadScope), nil
+	}
+	return &oauth2.ResponseOAUTH2{
+		ReturnCode: oauth2.CubeOAUTH2ErrCodeOK,
+		CliendID:   "test_client_id",
+		ClientType: 2002,
+		Username:   "testuser@mail.ru",
+		ExpiresIn:  3600,
+		UserID:     101010,
+	}, nil
+})
+
+func TestHandlerValidate(t *testing.T) {
+	s, addr := startHandler(t, testValidator)
+	defer s.Close()
+	client := oauth2.CreateClient(oauth2.ClientConfig{Address: addr})
+	defer client.Close()
+
+	res, err := client.Validate(context.Background(), "token", "scope")
+	require.NoError(t, err)
+	require.Equal(t, oauth2.CubeOAUTH2ErrCodeOK, res.ReturnCode)
+	require.Equal(t, "testuser@mail.ru", res.Username)
+	require.Equal(t, int64(101010), res.UserID)
+
+	codes := []struct {
+		token, scope string
+		code         int32
+	}{
+		{"unknown", "scope", oauth2.CubeOAUTH2ErrCodeTokenNotFound},
+		{"token", "other", oauth2.CubeOAUTH2ErrCodeBadScope},
+		{"broken", "scope", oauth2.CubeOAUTH2ErrCodeDBError},
+	}
+	for _, c := range codes {
+		res, err = client.Validate(context.Background(), c.token, c.scope)
+		require.NoError(t, err)
+		require.Equal(t, c.code, res.ReturnCode)
+	}
+}
+
+func TestHandlerMalformedRequest(t *testing.T) {
+	s, addr := startHandler(t, testValidator)
+	defer s.Close()
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	cases := []struct {
+		body []byte
+		code int32
+	}{
+		{flat(buildInt32(0x7), buildString("token"), buildString("scope")), oauth2.CubeOAUTH2ErrCodeUnknownMSG},
+		{flat(buildInt32(0x1), buildString("token")), oauth2.CubeOAUTH2ErrCodeBadPacket},
+		{flat(buildInt32(0x1), buildString("token"), buildString("scope"), buildInt32(0)), oauth2.CubeOAUTH2ErrCodeBadPacket},
+	}
+	d := oauth2.CreateDecoder(conn)
+	for i, c := range cases {
+		req := flat(buildInt32(oauth2.SvcID), buildInt32(int32(len(c.body))), buildInt32(int32(i)), c.body)
+		_, err = conn.Write(req)
+		require.NoError(t, err)
+		res := &oauth2.ResponseOAUTH2{}
+		require.NoError(t, d.ReadOAUTH2Resp(res))
+		require.Equal(t, c.code, res.ReturnCode)
+	}
+}
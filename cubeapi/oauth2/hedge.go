@@ -0,0 +1,183 @@
+package oauth2
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// defaults of HedgeConfig
+const (
+	DefaultHedgeMinDelay = 10 * time.Millisecond
+	DefaultHedgeBudget   = 0.05
+)
+
+// HedgeConfig configures hedged requests. Request not answered within delay
+// is sent to another endpoint too, the first response received is taken
+// and the other request is cancelled. Delay is Percentile of latencies of
+// recent requests
+type HedgeConfig struct {
+	// Percentile of latencies used as delay, e.g. 0.95. Requests aren't
+	// hedged if zero
+	Percentile float64
+	// MinDelay bounds delay from below, it is used as delay until enough
+	// latencies are observed. DefaultHedgeMinDelay if zero
+	MinDelay time.Duration
+	// Budget is the largest ratio of hedged requests to recent requests,
+	// so hedges don't come in bursts. DefaultHedgeBudget if zero
+	Budget float64
+}
+
+const (
+	// hedgeSamples is number of recent latencies delay is computed of
+	hedgeSamples = 256
+	// hedgeMinSamples is number of latencies needed to compute delay
+	hedgeMinSamples = 16
+	// hedgeBurst is number of hedges which can be sent in a row, budget is
+	// applied to window of hedgeBurst/Budget recent requests
+	hedgeBurst = 2
+)
+
+// hedger sends hedged requests and tracks latencies and budget
+type hedger struct {
+	conf    HedgeConfig
+	lock    sync.Mutex
+	samples [hedgeSamples]time.Duration
+	n       int
+	// window is number of recent requests budget is applied to
+	window int
+	// requests is number of all requests
+	requests int
+	// hedged are numbers of requests hedges were sent at, only ones of
+	// recent requests are kept
+	hedged []int
+}
+
+// createHedger creates hedger, nil if hedging is disabled
+func createHedger(conf HedgeConfig) *hedger {
+	if conf.Percentile <= 0 {
+		return nil
+	}
+	if conf.Percentile > 1 {
+		conf.Percentile = 1
+	}
+	if conf.MinDelay <= 0 {
+		conf.MinDelay = DefaultHedgeMinDelay
+	}
+	if conf.Budget <= 0 {
+		conf.Budget = DefaultHedgeBudget
+	}
+	return &hedger{conf: conf, window: int(math.Ceil(hedgeBurst / conf.Budget))}
+}
+
+// hedgeResult is result of one of hedged requests
+type hedgeResult struct {
+	r       *ResponseOAUTH2
+	err     error
+	primary bool
+}
+
+// do sends request, and hedged request if there is no response within
+// delay. The first response is returned, errors are returned only if both
+// requests failed, error of primary request is preferred
+func (h *hedger) do(ctx context.Context, c *Client, req *SendBuffer) (*ResponseOAUTH2, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	// cancels request which lost
+	defer cancel()
+	used := &usedEndpoints{}
+	results := make(chan hedgeResult, 2)
+	send := func(primary bool) {
+		start := time.Now()
+		r, err := c.send(ctx, req, used)
+		if err == nil {
+			h.observe(time.Since(start))
+		}
+		results <- hedgeResult{r: r, err: err, primary: primary}
+	}
+
+	delay := h.start()
+	sent := time.Now()
+	go send(true)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	pending := 1
+	primaryDone := false
+	var err error
+	for pending > 0 {
+		select {
+		case res := <-results:
+			pending--
+			primaryDone = primaryDone || res.primary
+			if res.err == nil {
+				if !primaryDone {
+					// latency of slow primary request is known to be longer
+					h.observe(time.Since(sent))
+				}
+				return res.r, nil
+			}
+			if res.primary || err == nil {
+				err = res.err
+			}
+		case <-timer.C:
+			if c.endpoints.count() > 1 && h.allow() {
+				pending++
+				go send(false)
+			}
+		}
+	}
+	return nil, err
+}
+
+// start counts request and returns delay of hedge
+func (h *hedger) start() time.Duration {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.requests++
+	if h.n < hedgeMinSamples {
+		return h.conf.MinDelay
+	}
+	n := h.n
+	if n > hedgeSamples {
+		n = hedgeSamples
+	}
+	sorted := make([]time.Duration, n)
+	copy(sorted, h.samples[:n])
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	delay := sorted[int(h.conf.Percentile*float64(n-1))]
+	if delay < h.conf.MinDelay {
+		delay = h.conf.MinDelay
+	}
+	return delay
+}
+
+// allow reports whether hedges of recent requests stay within budget with
+// one more, counting it
+func (h *hedger) allow() bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	i := 0
+	for i < len(h.hedged) && h.hedged[i] <= h.requests-h.window {
+		i++
+	}
+	h.hedged = h.hedged[i:]
+	window := h.requests
+	if window > h.window {
+		window = h.window
+	}
+	if float64(len(h.hedged)+1) > h.conf.Budget*float64(window) {
+		return false
+	}
+	h.hedged = append(h.hedged, h.requests)
+	return true
+}
+
+// observe records latency of successful request
+func (h *hedger) observe(latency time.Duration) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.samples[h.n%hedgeSamples] = latency
+	h.n++
+}
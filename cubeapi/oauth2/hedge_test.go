@@ -0,0 +1,132 @@
+package oauth2_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Apakhov/cube/cubeapi/oauth2"
+	"github.com/Apakhov/cube/cubeapi/oauth2/oauth2test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHedgeSlowEndpoint(t *testing.T) {
+	servers, addrs := startReplicas(2)
+	defer closeReplicas(servers)
+	servers[0].SetFaults(oauth2test.Fault{Delay: 500 * time.Millisecond})
+	client := oauth2.CreateClient(oauth2.ClientConfig{
+		Endpoints: addrs,
+		Hedge:     oauth2.HedgeConfig{Percentile: 0.95, MinDelay: 20 * time.Millisecond, Budget: 1},
+	})
+	defer client.Close()
+
+	start := time.Now()
+	r, err := client.Validate(context.Background(), "token", "scope")
+	require.NoError(t, err)
+	require.Equal(t, "testuser@mail.ru", r.Username)
+	require.True(t, time.Since(start) < 300*time.Millisecond, time.Since(start).String())
+	require.Equal(t, 1, servers[0].Requests())
+	require.Equal(t, 1, servers[1].Requests())
+
+	// request lost is cancelled
+	time.Sleep(50 * time.Millisecond)
+	for _, e := range client.Endpoints() {
+		require.Equal(t, 0, e.Outstanding, e.Address)
+		require.Equal(t, 0, e.Fails, e.Address)
+	}
+}
+
+func TestHedgeMultiplex(t *testing.T) {
+	servers, addrs := startReplicas(2)
+	defer closeReplicas(servers)
+	client := oauth2.CreateClient(oauth2.ClientConfig{
+		Endpoints: addrs,
+		Multiplex: true,
+		Hedge:     oauth2.HedgeConfig{Percentile: 0.5, MinDelay: time.Nanosecond, Budget: 1},
+	})
+	defer client.Close()
+
+	// hedged requests share request frame
+	for i := 0; i < 20; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		r, err := client.Validate(ctx, "token", "scope")
+		cancel()
+		require.NoError(t, err)
+		require.Equal(t, "testuser@mail.ru", r.Username)
+	}
+}
+
+func TestHedgeBudget(t *testing.T) {
+	servers, addrs := startReplicas(2)
+	defer closeReplicas(servers)
+	for _, s := range servers {
+		s.SetFaults(oauth2test.Fault{Delay: 100 * time.Millisecond})
+	}
+	client := oauth2.CreateClient(oauth2.ClientConfig{
+		Endpoints: addrs,
+		Hedge:     oauth2.HedgeConfig{Percentile: 0.95, MinDelay: 10 * time.Millisecond, Budget: 0.5},
+	})
+	defer client.Close()
+
+	for i := 0; i < 4; i++ {
+		_, err := client.Validate(context.Background(), "token", "scope")
+		require.NoError(t, err)
+	}
+	// every second request is hedged
+	require.Equal(t, 6, servers[0].Requests()+servers[1].Requests())
+}
+
+func TestHedgeBudgetBurst(t *testing.T) {
+	servers, addrs := startReplicas(2)
+	defer closeReplicas(servers)
+	client := oauth2.CreateClient(oauth2.ClientConfig{
+		Endpoints: addrs,
+		Hedge:     oauth2.HedgeConfig{Percentile: 0.5, MinDelay: 30 * time.Millisecond, Budget: 0.1},
+	})
+	defer client.Close()
+
+	// budget isn't saved up over quiet period
+	for i := 0; i < 100; i++ {
+		_, err := client.Validate(context.Background(), "token", "scope")
+		require.NoError(t, err)
+	}
+	for _, s := range servers {
+		s.SetFaults(oauth2test.Fault{Delay: 50 * time.Millisecond})
+	}
+	for i := 0; i < 10; i++ {
+		_, err := client.Validate(context.Background(), "token", "scope")
+		require.NoError(t, err)
+	}
+	// the first two slow requests are hedged, the next hedge is allowed 20
+	// requests after the first one
+	require.Equal(t, 112, servers[0].Requests()+servers[1].Requests())
+}
+
+func TestHedgeFastEndpoints(t *testing.T) {
+	servers, addrs := startReplicas(2)
+	defer closeReplicas(servers)
+	client := oauth2.CreateClient(oauth2.ClientConfig{
+		Endpoints: addrs,
+		Hedge:     oauth2.HedgeConfig{Percentile: 0.5, MinDelay: 50 * time.Millisecond, Budget: 1},
+	})
+	defer client.Close()
+
+	for i := 0; i < 20; i++ {
+		_, err := client.Validate(context.Background(), "token", "scope")
+		require.NoError(t, err)
+	}
+	require.Equal(t, 20, servers[0].Requests()+servers[1].Requests())
+
+	// the only endpoint isn't hedged
+	s := oauth2test.CreateServer()
+	defer s.Close()
+	s.AddToken("token", "scope", oauth2.ResponseOAUTH2{Username: "testuser@mail.ru"})
+	conf := s.ClientConfig()
+	conf.Hedge = oauth2.HedgeConfig{Percentile: 0.5, MinDelay: time.Millisecond, Budget: 1}
+	client = oauth2.CreateClient(conf)
+	defer client.Close()
+	s.SetFaults(oauth2test.Fault{Delay: 20 * time.Millisecond})
+	_, err := client.Validate(context.Background(), "token", "scope")
+	require.NoError(t, err)
+	require.Equal(t, 1, s.Requests())
+}
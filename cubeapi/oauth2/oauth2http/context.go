@@ -0,0 +1,57 @@
+package oauth2http
+
+import (
+	"context"
+
+	"github.com/Apakhov/cube/cubeapi/oauth2"
+)
+
+type contextKey struct{}
+
+// NewContext returns context carrying response of cube
+func NewContext(ctx context.Context, r *oauth2.ResponseOAUTH2) context.Context {
+	return context.WithValue(ctx, contextKey{}, r)
+}
+
+// ResponseFromContext returns response of cube put into context by
+// Middleware
+func ResponseFromContext(ctx context.Context) (*oauth2.ResponseOAUTH2, bool) {
+	r, ok := ctx.Value(contextKey{}).(*oauth2.ResponseOAUTH2)
+	return r, ok
+}
+
+// UserID returns id of authenticated user
+func UserID(ctx context.Context) (int64, bool) {
+	r, ok := ResponseFromContext(ctx)
+	if !ok {
+		return 0, false
+	}
+	return r.UserID, true
+}
+
+// Username returns name of authenticated user
+func Username(ctx context.Context) (string, bool) {
+	r, ok := ResponseFromContext(ctx)
+	if !ok {
+		return "", false
+	}
+	return r.Username, true
+}
+
+// ClientID returns id of client token is issued to
+func ClientID(ctx context.Context) (string, bool) {
+	r, ok := ResponseFromContext(ctx)
+	if !ok {
+		return "", false
+	}
+	return r.CliendID, true
+}
+
+// ClientType returns type of client token is issued to
+func ClientType(ctx context.Context) (int32, bool) {
+	r, ok := ResponseFromContext(ctx)
+	if !ok {
+		return 0, false
+	}
+	return r.ClientType, true
+}
@@ -0,0 +1,96 @@
+package oauth2http
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Apakhov/cube/cubeapi/oauth2"
+)
+
+// IntrospectionResponse is RFC 7662 introspection response
+type IntrospectionResponse struct {
+	Active     bool   `json:"active"`
+	Scope      string `json:"scope,omitempty"`
+	ClientID   string `json:"client_id,omitempty"`
+	Username   string `json:"username,omitempty"`
+	TokenType  string `json:"token_type,omitempty"`
+	Expiration int64  `json:"exp,omitempty"`
+	Subject    string `json:"sub,omitempty"`
+}
+
+// introspectionError is error response of introspection endpoint
+type introspectionError struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// IntrospectionHandler answers RFC 7662 introspection requests, POST with
+// form fields token and scope, validating token by v. Token is active if
+// cube accepts it with scope. ExpiresIn of cube response is turned into
+// absolute exp, UserID into sub
+func IntrospectionHandler(v oauth2.Validator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeJSON(w, http.StatusMethodNotAllowed, &introspectionError{Error: "invalid_request"})
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			writeJSON(w, http.StatusBadRequest, &introspectionError{
+				Error:            "invalid_request",
+				ErrorDescription: "malformed form",
+			})
+			return
+		}
+		token, scope := r.PostForm.Get("token"), r.PostForm.Get("scope")
+		if token == "" {
+			writeJSON(w, http.StatusBadRequest, &introspectionError{
+				Error:            "invalid_request",
+				ErrorDescription: "token is required",
+			})
+			return
+		}
+
+		resp, err := oauth2.ResponseOf(v.Validate(r.Context(), token, scope))
+		if err != nil {
+			if r.Context().Err() != nil {
+				return
+			}
+			writeJSON(w, http.StatusServiceUnavailable, &introspectionError{Error: "temporarily_unavailable"})
+			return
+		}
+		switch resp.ReturnCode {
+		case oauth2.CubeOAUTH2ErrCodeOK:
+			writeJSON(w, http.StatusOK, introspection(resp, scope, time.Now()))
+		case oauth2.CubeOAUTH2ErrCodeTokenNotFound, oauth2.CubeOAUTH2ErrCodeBadClient, oauth2.CubeOAUTH2ErrCodeBadScope:
+			writeJSON(w, http.StatusOK, &IntrospectionResponse{Active: false})
+		default:
+			writeJSON(w, http.StatusServiceUnavailable, &introspectionError{
+				Error:            "temporarily_unavailable",
+				ErrorDescription: oauth2.ErrorResponse(resp.ReturnCode).ErrorString,
+			})
+		}
+	})
+}
+
+// introspection turns successful cube response into introspection response
+func introspection(r *oauth2.ResponseOAUTH2, scope string, now time.Time) *IntrospectionResponse {
+	return &IntrospectionResponse{
+		Active:     true,
+		Scope:      scope,
+		ClientID:   r.CliendID,
+		Username:   r.Username,
+		TokenType:  "Bearer",
+		Expiration: now.Add(time.Duration(r.ExpiresIn) * time.Second).Unix(),
+		Subject:    strconv.FormatInt(r.UserID, 10),
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
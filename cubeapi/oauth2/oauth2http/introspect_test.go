@@ -0,0 +1,68 @@
+package oauth2http_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Apakhov/cube/cubeapi/oauth2/oauth2http"
+	"github.com/stretchr/testify/require"
+)
+
+func introspect(form url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/introspect", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return serve(oauth2http.IntrospectionHandler(testValidator), r)
+}
+
+func TestIntrospectionActive(t *testing.T) {
+	w := introspect(url.Values{"token": {"token"}, "scope": {"scope"}})
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	resp := &oauth2http.IntrospectionResponse{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), resp))
+	exp := time.Now().Add(time.Hour).Unix()
+	require.InDelta(t, exp, resp.Expiration, 2)
+	resp.Expiration = 0
+	require.Equal(t, &oauth2http.IntrospectionResponse{
+		Active:    true,
+		Scope:     "scope",
+		ClientID:  "test_client_id",
+		Username:  "testuser@mail.ru",
+		TokenType: "Bearer",
+		Subject:   "101010",
+	}, resp)
+}
+
+func TestIntrospectionInactive(t *testing.T) {
+	for _, form := range []url.Values{
+		{"token": {"unknown"}, "scope": {"scope"}},
+		{"token": {"token"}, "scope": {"admin"}},
+		{"token": {"badclient"}, "scope": {"scope"}},
+	} {
+		w := introspect(form)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.JSONEq(t, `{"active":false}`, w.Body.String())
+	}
+}
+
+func TestIntrospectionErrors(t *testing.T) {
+	w := introspect(url.Values{"scope": {"scope"}})
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.JSONEq(t, `{"error":"invalid_request","error_description":"token is required"}`, w.Body.String())
+
+	w = introspect(url.Values{"token": {"dberror"}, "scope": {"scope"}})
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	w = introspect(url.Values{"token": {"broken"}, "scope": {"scope"}})
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	r := httptest.NewRequest("GET", "/introspect?token=token&scope=scope", nil)
+	w = serve(oauth2http.IntrospectionHandler(testValidator), r)
+	require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	require.Equal(t, "POST", w.Header().Get("Allow"))
+}
@@ -0,0 +1,145 @@
+// Package oauth2http authenticates HTTP requests with Bearer tokens
+// validated by cube OAUTH2 service, see RFC 6750
+package oauth2http
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Apakhov/cube/cubeapi/oauth2"
+)
+
+// TokenSource extracts token from request. It returns empty token if
+// request has no token, ok is false if token is malformed
+type TokenSource func(r *http.Request) (token string, ok bool)
+
+// FromHeader extracts token from Authorization header with Bearer scheme
+func FromHeader() TokenSource {
+	return func(r *http.Request) (string, bool) {
+		parts := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+		if !strings.EqualFold(parts[0], "Bearer") {
+			return "", true
+		}
+		if len(parts) < 2 {
+			return "", false
+		}
+		token := strings.TrimSpace(parts[1])
+		return token, token != "" && !strings.ContainsAny(token, " \t")
+	}
+}
+
+// FromQuery extracts token from query parameter, "access_token" if name is
+// empty
+func FromQuery(name string) TokenSource {
+	if name == "" {
+		name = "access_token"
+	}
+	return func(r *http.Request) (string, bool) {
+		values := r.URL.Query()[name]
+		if len(values) > 1 {
+			return "", false
+		}
+		if len(values) == 0 {
+			return "", true
+		}
+		return values[0], values[0] != ""
+	}
+}
+
+// FromCookie extracts token from cookie with name
+func FromCookie(name string) TokenSource {
+	return func(r *http.Request) (string, bool) {
+		c, err := r.Cookie(name)
+		if err != nil {
+			return "", true
+		}
+		return c.Value, c.Value != ""
+	}
+}
+
+// Realm is realm of WWW-Authenticate challenges
+const Realm = "cube"
+
+// Middleware authenticates requests with token validated by v with
+// requiredScope and puts response of cube into request context, see
+// ResponseFromContext. Token is taken from the first of sources having it,
+// FromHeader is used if no sources are given. Requests are rejected with
+//
+//	400 if token is malformed or given by several sources,
+//	401 if there is no token, token isn't found or client is bad,
+//	403 if scope is bad,
+//	503 if cube fails or can't be reached
+func Middleware(v oauth2.Validator, requiredScope string, sources ...TokenSource) func(http.Handler) http.Handler {
+	if len(sources) == 0 {
+		sources = []TokenSource{FromHeader()}
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := extractToken(r, sources)
+			if !ok {
+				reject(w, http.StatusBadRequest, "invalid_request", "malformed token", requiredScope)
+				return
+			}
+			if token == "" {
+				reject(w, http.StatusUnauthorized, "", "", requiredScope)
+				return
+			}
+
+			resp, err := oauth2.ResponseOf(v.Validate(r.Context(), token, requiredScope))
+			if err != nil {
+				if r.Context().Err() != nil {
+					// client is gone, nobody reads response
+					return
+				}
+				http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+				return
+			}
+
+			descr := oauth2.ErrorResponse(resp.ReturnCode).ErrorString
+			switch resp.ReturnCode {
+			case oauth2.CubeOAUTH2ErrCodeOK:
+				next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), resp)))
+			case oauth2.CubeOAUTH2ErrCodeTokenNotFound, oauth2.CubeOAUTH2ErrCodeBadClient:
+				reject(w, http.StatusUnauthorized, "invalid_token", descr, requiredScope)
+			case oauth2.CubeOAUTH2ErrCodeBadScope:
+				reject(w, http.StatusForbidden, "insufficient_scope", descr, requiredScope)
+			default:
+				http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			}
+		})
+	}
+}
+
+// extractToken returns token of the first source having it, ok is false if
+// token is malformed or sources give different tokens
+func extractToken(r *http.Request, sources []TokenSource) (token string, ok bool) {
+	for _, source := range sources {
+		t, ok := source(r)
+		if !ok {
+			return "", false
+		}
+		if t == "" {
+			continue
+		}
+		if token != "" && token != t {
+			return "", false
+		}
+		token = t
+	}
+	return token, true
+}
+
+// reject answers with status and RFC 6750 challenge, errCode is omitted if
+// empty
+func reject(w http.ResponseWriter, status int, errCode, descr, scope string) {
+	challenge := fmt.Sprintf("Bearer realm=%q", Realm)
+	if errCode != "" {
+		challenge += fmt.Sprintf(", error=%q, error_description=%q", errCode, descr)
+	}
+	if scope != "" {
+		challenge += fmt.Sprintf(", scope=%q", scope)
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	http.Error(w, http.StatusText(status), status)
+}
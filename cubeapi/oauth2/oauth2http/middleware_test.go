@@ -0,0 +1,161 @@
+package oauth2http_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Apakhov/cube/cubeapi/oauth2"
+	"github.com/Apakhov/cube/cubeapi/oauth2/oauth2http"
+	"github.com/stretchr/testify/require"
+)
+
+var testValidator = oauth2.ValidatorFunc(func(ctx context.Context, token, scope string) (*oauth2.ResponseOAUTH2, error) {
+	switch token {
+	case "token":
+		if scope != "scope" {
+			return oauth2.ErrorResponse(oauth2.CubeOAUTH2ErrCodeBadScope), nil
+		}
+		return &oauth2.ResponseOAUTH2{
+			ReturnCode: oauth2.CubeOAUTH2ErrCodeOK,
+			CliendID:   "test_client_id",
+			ClientType: 2002,
+			Username:   "testuser@mail.ru",
+			ExpiresIn:  3600,
+			UserID:     101010,
+		}, nil
+	case "badclient":
+		return oauth2.ErrorResponse(oauth2.CubeOAUTH2ErrCodeBadClient), nil
+	case "dberror":
+		return oauth2.ErrorResponse(oauth2.CubeOAUTH2ErrCodeDBError), nil
+	case "broken":
+		return nil, errors.New("connection refused")
+	}
+	return oauth2.ErrorResponse(oauth2.CubeOAUTH2ErrCodeTokenNotFound), nil
+})
+
+// userHandler writes username of authenticated user
+var userHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	username, ok := oauth2http.Username(r.Context())
+	if !ok {
+		http.Error(w, "no user", http.StatusInternalServerError)
+		return
+	}
+	w.Write([]byte(username))
+})
+
+func serve(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestMiddlewareStatus(t *testing.T) {
+	h := oauth2http.Middleware(testValidator, "scope")(userHandler)
+	cases := []struct {
+		auth      string
+		status    int
+		challenge string
+	}{
+		{"Bearer token", http.StatusOK, ""},
+		{"bearer  token ", http.StatusOK, ""},
+		{"", http.StatusUnauthorized, `Bearer realm="cube", scope="scope"`},
+		{"Basic dXNlcjpwYXNz", http.StatusUnauthorized, `Bearer realm="cube", scope="scope"`},
+		{"Bearer", http.StatusBadRequest,
+			`Bearer realm="cube", error="invalid_request", error_description="malformed token", scope="scope"`},
+		{"Bearer a b", http.StatusBadRequest,
+			`Bearer realm="cube", error="invalid_request", error_description="malformed token", scope="scope"`},
+		{"Bearer unknown", http.StatusUnauthorized,
+			`Bearer realm="cube", error="invalid_token", error_description="token not found", scope="scope"`},
+		{"Bearer badclient", http.StatusUnauthorized,
+			`Bearer realm="cube", error="invalid_token", error_description="bad client", scope="scope"`},
+		{"Bearer dberror", http.StatusServiceUnavailable, ""},
+		{"Bearer broken", http.StatusServiceUnavailable, ""},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/", nil)
+		if c.auth != "" {
+			r.Header.Set("Authorization", c.auth)
+		}
+		w := serve(h, r)
+		require.Equal(t, c.status, w.Code, c.auth)
+		require.Equal(t, c.challenge, w.Header().Get("WWW-Authenticate"), c.auth)
+		if c.status == http.StatusOK {
+			require.Equal(t, "testuser@mail.ru", w.Body.String())
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer token")
+	w := serve(oauth2http.Middleware(testValidator, "admin")(userHandler), r)
+	require.Equal(t, http.StatusForbidden, w.Code)
+	require.Equal(t,
+		`Bearer realm="cube", error="insufficient_scope", error_description="bad scope", scope="admin"`,
+		w.Header().Get("WWW-Authenticate"))
+}
+
+func TestMiddlewareSources(t *testing.T) {
+	h := oauth2http.Middleware(testValidator, "scope",
+		oauth2http.FromHeader(), oauth2http.FromQuery(""), oauth2http.FromCookie("token"))
+
+	r := httptest.NewRequest("GET", "/?access_token=token", nil)
+	require.Equal(t, http.StatusOK, serve(h(userHandler), r).Code)
+
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: "token", Value: "token"})
+	require.Equal(t, http.StatusOK, serve(h(userHandler), r).Code)
+
+	r = httptest.NewRequest("GET", "/?access_token=token", nil)
+	r.Header.Set("Authorization", "Bearer token")
+	require.Equal(t, http.StatusOK, serve(h(userHandler), r).Code)
+
+	// different tokens are ambiguous
+	r = httptest.NewRequest("GET", "/?access_token=unknown", nil)
+	r.Header.Set("Authorization", "Bearer token")
+	require.Equal(t, http.StatusBadRequest, serve(h(userHandler), r).Code)
+
+	r = httptest.NewRequest("GET", "/?access_token=token&access_token=token", nil)
+	require.Equal(t, http.StatusBadRequest, serve(h(userHandler), r).Code)
+
+	// only header is used by default
+	r = httptest.NewRequest("GET", "/?access_token=token", nil)
+	require.Equal(t, http.StatusUnauthorized, serve(oauth2http.Middleware(testValidator, "scope")(userHandler), r).Code)
+}
+
+func TestContextAccessors(t *testing.T) {
+	var ctx context.Context
+	h := oauth2http.Middleware(testValidator, "scope")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx = r.Context()
+	}))
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer token")
+	serve(h, r)
+
+	resp, ok := oauth2http.ResponseFromContext(ctx)
+	require.True(t, ok)
+	require.Equal(t, int32(3600), resp.ExpiresIn)
+	userID, ok := oauth2http.UserID(ctx)
+	require.True(t, ok)
+	require.Equal(t, int64(101010), userID)
+	clientID, ok := oauth2http.ClientID(ctx)
+	require.True(t, ok)
+	require.Equal(t, "test_client_id", clientID)
+	clientType, ok := oauth2http.ClientType(ctx)
+	require.True(t, ok)
+	require.Equal(t, int32(2002), clientType)
+
+	_, ok = oauth2http.Username(context.Background())
+	require.False(t, ok)
+}
+
+func TestMiddlewareReturnCodeError(t *testing.T) {
+	v := oauth2.ValidatorFunc(func(ctx context.Context, token, scope string) (*oauth2.ResponseOAUTH2, error) {
+		return nil, oauth2.ErrBadScope
+	})
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer token")
+	w := serve(oauth2http.Middleware(v, "scope")(userHandler), r)
+	require.Equal(t, http.StatusForbidden, w.Code)
+}
@@ -0,0 +1,144 @@
+package oauth2test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+)
+
+// Certificates are PEM encoded certificates and keys of test certificate
+// authority, server and client signed by it
+type Certificates struct {
+	CACert     []byte
+	ServerCert []byte
+	ServerKey  []byte
+	ClientCert []byte
+	ClientKey  []byte
+}
+
+// CreateCertificates generates Certificates valid for a day. Server
+// certificate is valid for hosts, ip addresses or names, and 127.0.0.1 if
+// hosts are empty
+func CreateCertificates(hosts ...string) *Certificates {
+	if len(hosts) == 0 {
+		hosts = []string{"127.0.0.1"}
+	}
+	caKey := generateKey()
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "oauth2test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER := signCertificate(ca, ca, caKey, caKey)
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		panic(fmt.Sprintf("oauth2test: failed to parse CA certificate: %v", err))
+	}
+
+	server := leafCertificate(2, "oauth2test server", x509.ExtKeyUsageServerAuth)
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			server.IPAddresses = append(server.IPAddresses, ip)
+		} else {
+			server.DNSNames = append(server.DNSNames, h)
+		}
+	}
+	serverKey := generateKey()
+	client := leafCertificate(3, "oauth2test client", x509.ExtKeyUsageClientAuth)
+	clientKey := generateKey()
+
+	return &Certificates{
+		CACert:     encodePEM("CERTIFICATE", caDER),
+		ServerCert: encodePEM("CERTIFICATE", signCertificate(server, ca, serverKey, caKey)),
+		ServerKey:  encodeKey(serverKey),
+		ClientCert: encodePEM("CERTIFICATE", signCertificate(client, ca, clientKey, caKey)),
+		ClientKey:  encodeKey(clientKey),
+	}
+}
+
+// ServerTLSConfig returns config of server presenting server certificate,
+// requiring client certificates signed by CA if requireClientCert
+func (c *Certificates) ServerTLSConfig(requireClientCert bool) *tls.Config {
+	conf := &tls.Config{Certificates: []tls.Certificate{keyPair(c.ServerCert, c.ServerKey)}}
+	if requireClientCert {
+		conf.ClientCAs = c.CertPool()
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return conf
+}
+
+// ClientTLSConfig returns config of client trusting CA, presenting client
+// certificate if withClientCert
+func (c *Certificates) ClientTLSConfig(withClientCert bool) *tls.Config {
+	conf := &tls.Config{RootCAs: c.CertPool()}
+	if withClientCert {
+		conf.Certificates = []tls.Certificate{keyPair(c.ClientCert, c.ClientKey)}
+	}
+	return conf
+}
+
+// CertPool returns pool of CA certificate
+func (c *Certificates) CertPool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(c.CACert)
+	return pool
+}
+
+func leafCertificate(serial int64, name string, usage x509.ExtKeyUsage) *x509.Certificate {
+	return &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+}
+
+func generateKey() *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(fmt.Sprintf("oauth2test: failed to generate key: %v", err))
+	}
+	return key
+}
+
+func signCertificate(cert, parent *x509.Certificate, key, parentKey *ecdsa.PrivateKey) []byte {
+	der, err := x509.CreateCertificate(rand.Reader, cert, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		panic(fmt.Sprintf("oauth2test: failed to create certificate: %v", err))
+	}
+	return der
+}
+
+func encodeKey(key *ecdsa.PrivateKey) []byte {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		panic(fmt.Sprintf("oauth2test: failed to marshal key: %v", err))
+	}
+	return encodePEM("EC PRIVATE KEY", der)
+}
+
+func encodePEM(typ string, der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+}
+
+func keyPair(cert, key []byte) tls.Certificate {
+	pair, err := tls.X509KeyPair(cert, key)
+	if err != nil {
+		panic(fmt.Sprintf("oauth2test: failed to load key pair: %v", err))
+	}
+	return pair
+}
@@ -0,0 +1,130 @@
+package oauth2test
+
+import (
+	"encoding/binary"
+	"net"
+	"time"
+)
+
+// Fault describes how server misbehaves answering request. Zero Fault
+// answers correctly
+type Fault struct {
+	// Times is number of requests fault is applied to, 0 means all requests
+	Times int
+	// Delay delays response
+	Delay time.Duration
+	// Trickle writes response byte by byte, TrickleInterval apart
+	Trickle         bool
+	TrickleInterval time.Duration
+	// Close closes connection after CloseAfter bytes of response are written
+	Close      bool
+	CloseAfter int
+	// SvcID replaces svc id of response if not zero
+	SvcID int32
+	// BodyLengthDelta is added to BodyLength of response. Positive delta
+	// appends zero bytes to body, so response fields don't fill it completely
+	BodyLengthDelta int32
+	// NegativeStringLen replaces length of first string of response with -1
+	NegativeStringLen bool
+	// RequestIDDelta is added to RequestID of response
+	RequestIDDelta int32
+}
+
+// faultScript is sequence of faults applied one after another
+type faultScript struct {
+	faults []Fault
+	used   int
+}
+
+// next returns fault for next request, nil if script is over
+func (fs *faultScript) next() *Fault {
+	for len(fs.faults) > 0 {
+		f := fs.faults[0]
+		if f.Times == 0 {
+			return &f
+		}
+		if fs.used < f.Times {
+			fs.used++
+			return &f
+		}
+		fs.faults = fs.faults[1:]
+		fs.used = 0
+	}
+	return nil
+}
+
+// SetFaults sets global fault script applied to requests with tokens having
+// no own script. Every fault is applied to Times requests, then the next
+// one is used. Server answers correctly after script is over. Calling
+// without faults clears script
+func (s *Server) SetFaults(faults ...Fault) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.faults = &faultScript{faults: faults}
+}
+
+// SetTokenFaults sets fault script applied to requests with token, see
+// SetFaults
+func (s *Server) SetTokenFaults(token string, faults ...Fault) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(faults) == 0 {
+		delete(s.tokenFaults, token)
+		return
+	}
+	s.tokenFaults[token] = &faultScript{faults: faults}
+}
+
+// nextFault returns fault for request with token, nil if request should be
+// answered correctly. Must be called under lock
+func (s *Server) nextFault(token string) *Fault {
+	if fs, ok := s.tokenFaults[token]; ok {
+		return fs.next()
+	}
+	return s.faults.next()
+}
+
+// corrupt applies fault to encoded response
+func (f *Fault) corrupt(resp []byte) []byte {
+	if f.SvcID != 0 {
+		binary.LittleEndian.PutUint32(resp[0:4], uint32(f.SvcID))
+	}
+	if f.BodyLengthDelta != 0 {
+		bodyLen := int32(binary.LittleEndian.Uint32(resp[4:8])) + f.BodyLengthDelta
+		binary.LittleEndian.PutUint32(resp[4:8], uint32(bodyLen))
+		if f.BodyLengthDelta > 0 {
+			resp = append(resp, make([]byte, f.BodyLengthDelta)...)
+		}
+	}
+	if f.RequestIDDelta != 0 {
+		requestID := int32(binary.LittleEndian.Uint32(resp[8:12])) + f.RequestIDDelta
+		binary.LittleEndian.PutUint32(resp[8:12], uint32(requestID))
+	}
+	if f.NegativeStringLen {
+		// first string follows header and return code in both layouts
+		binary.LittleEndian.PutUint32(resp[16:20], 0xFFFFFFFF)
+	}
+	return resp
+}
+
+// write writes response applying fault, ok is false if connection is closed
+func (f *Fault) write(conn net.Conn, resp []byte) (ok bool, err error) {
+	time.Sleep(f.Delay)
+	resp = f.corrupt(resp)
+	if f.Close && f.CloseAfter < len(resp) {
+		resp = resp[:f.CloseAfter]
+	}
+	if f.Trickle {
+		for i := range resp {
+			if i > 0 {
+				time.Sleep(f.TrickleInterval)
+			}
+			if _, err = conn.Write(resp[i : i+1]); err != nil {
+				return false, err
+			}
+		}
+	} else if _, err = conn.Write(resp); err != nil {
+		return false, err
+	}
+	return !f.Close, nil
+}
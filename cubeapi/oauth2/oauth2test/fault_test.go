@@ -0,0 +1,112 @@
+package oauth2test_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Apakhov/cube/cubeapi"
+	"github.com/Apakhov/cube/cubeapi/oauth2"
+	"github.com/Apakhov/cube/cubeapi/oauth2/oauth2test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFaultErrors(t *testing.T) {
+	testCases := []struct {
+		fault oauth2test.Fault
+		err   error
+	}{
+		{oauth2test.Fault{Close: true, CloseAfter: 20}, oauth2.ErrNotEnoughData},
+		{oauth2test.Fault{Close: true}, oauth2.ErrNotEnoughData},
+		{oauth2test.Fault{SvcID: 0x42}, oauth2.ErrIncorrectSVCID},
+		{oauth2test.Fault{BodyLengthDelta: 4}, oauth2.ErrIncorrectBodyLen},
+		{oauth2test.Fault{BodyLengthDelta: -4}, oauth2.ErrIncorrectLen},
+		{oauth2test.Fault{NegativeStringLen: true}, oauth2.ErrIncorrectData},
+	}
+	for i, c := range testCases {
+		s := oauth2test.CreateServer()
+		s.AddToken("token", "scope", testResponse)
+		s.SetFaults(c.fault)
+		client := oauth2.CreateClient(s.ClientConfig())
+
+		_, err := client.Validate(context.Background(), "token", "scope")
+		require.True(t, errors.Is(err, c.err), fmt.Sprintf("%d expected error", i))
+
+		client.Close()
+		s.Close()
+	}
+}
+
+func TestFaultTrickle(t *testing.T) {
+	s := oauth2test.CreateServer()
+	defer s.Close()
+	s.AddToken("token", "scope", testResponse)
+	s.SetFaults(oauth2test.Fault{Trickle: true, TrickleInterval: time.Millisecond})
+	client := oauth2.CreateClient(s.ClientConfig())
+	defer client.Close()
+
+	res, err := client.Validate(context.Background(), "token", "scope")
+	require.NoError(t, err)
+	require.Equal(t, testResponse, *res)
+}
+
+func TestFaultDelay(t *testing.T) {
+	s := oauth2test.CreateServer()
+	defer s.Close()
+	s.AddToken("token", "scope", testResponse)
+	s.SetFaults(oauth2test.Fault{Delay: time.Second, Times: 1})
+	client := oauth2.CreateClient(s.ClientConfig())
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	_, err := client.Validate(ctx, "token", "scope")
+	require.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	// script is over
+	res, err := client.Validate(context.Background(), "token", "scope")
+	require.NoError(t, err)
+	require.Equal(t, testResponse, *res)
+}
+
+func TestFaultScript(t *testing.T) {
+	s := oauth2test.CreateServer()
+	defer s.Close()
+	s.AddToken("token", "scope", testResponse)
+	s.AddToken("other", "scope", testResponse)
+	s.SetTokenFaults("token",
+		oauth2test.Fault{SvcID: 0x42, Times: 2},
+		oauth2test.Fault{NegativeStringLen: true, Times: 1},
+	)
+	client := oauth2.CreateClient(s.ClientConfig())
+	defer client.Close()
+
+	for _, exp := range []error{oauth2.ErrIncorrectSVCID, oauth2.ErrIncorrectSVCID, oauth2.ErrIncorrectData, nil} {
+		_, err := client.Validate(context.Background(), "token", "scope")
+		require.True(t, errors.Is(err, exp))
+	}
+	_, err := client.Validate(context.Background(), "other", "scope")
+	require.NoError(t, err)
+}
+
+func TestFaultRequestID(t *testing.T) {
+	s := oauth2test.CreateServer()
+	defer s.Close()
+	s.AddToken("token", "scope", testResponse)
+	s.SetFaults(oauth2test.Fault{RequestIDDelta: 100, Times: 1})
+
+	errs := make(chan error, 1)
+	conf := s.ClientConfig()
+	conf.Multiplex = true
+	conf.ErrorHandler = func(err error) { errs <- err }
+	client := oauth2.CreateClient(conf)
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	_, err := client.Validate(ctx, "token", "scope")
+	require.True(t, errors.Is(err, context.DeadlineExceeded))
+	require.True(t, errors.Is(<-errs, cubeapi.ErrUnknownRequestID))
+}
@@ -0,0 +1,254 @@
+// Package oauth2test provides in-process cube OAUTH2 server for integration
+// tests of code using oauth2 package
+package oauth2test
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/Apakhov/cube/cubeapi"
+	"github.com/Apakhov/cube/cubeapi/oauth2"
+)
+
+// Server is cube OAUTH2 server answering from programmable token table.
+// Unknown tokens are answered with CubeOAUTH2ErrCodeTokenNotFound, known
+// tokens with unknown scope with CubeOAUTH2ErrCodeBadScope
+type Server struct {
+	listener    net.Listener
+	tempDir     string
+	lock        sync.Mutex
+	tokens      map[string]map[string]oauth2.ResponseOAUTH2
+	conns       map[net.Conn]struct{}
+	faults      *faultScript
+	tokenFaults map[string]*faultScript
+	requests    int
+	closed      bool
+	wg          sync.WaitGroup
+}
+
+// CreateServer creates and starts Server listening on loopback tcp address
+func CreateServer() *Server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("oauth2test: failed to listen: %v", err))
+	}
+	return CreateServerWithListener(l)
+}
+
+// CreateTLSServer creates and starts Server listening on loopback tcp
+// address and terminating TLS configured by conf, see Certificates
+func CreateTLSServer(conf *tls.Config) *Server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("oauth2test: failed to listen: %v", err))
+	}
+	return CreateServerWithListener(tls.NewListener(l, conf))
+}
+
+// CreateUnixServer creates and starts Server listening on unix socket in
+// temporary directory
+func CreateUnixServer() *Server {
+	dir, err := ioutil.TempDir("", "oauth2test")
+	if err != nil {
+		panic(fmt.Sprintf("oauth2test: failed to create temp dir: %v", err))
+	}
+	l, err := net.Listen("unix", filepath.Join(dir, "cube.sock"))
+	if err != nil {
+		os.RemoveAll(dir)
+		panic(fmt.Sprintf("oauth2test: failed to listen: %v", err))
+	}
+	s := CreateServerWithListener(l)
+	s.tempDir = dir
+	return s
+}
+
+// CreateServerWithListener creates and starts Server accepting connections
+// from l
+func CreateServerWithListener(l net.Listener) *Server {
+	s := &Server{
+		listener:    l,
+		tokens:      make(map[string]map[string]oauth2.ResponseOAUTH2),
+		conns:       make(map[net.Conn]struct{}),
+		faults:      &faultScript{},
+		tokenFaults: make(map[string]*faultScript),
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+// Network returns network of server, "tcp" or "unix"
+func (s *Server) Network() string {
+	return s.listener.Addr().Network()
+}
+
+// Addr returns address of server
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// URL returns address of server with network as scheme, e.g.
+// tcp://127.0.0.1:3333
+func (s *Server) URL() string {
+	return s.Network() + "://" + s.Addr()
+}
+
+// ClientConfig returns config of client connecting to server
+func (s *Server) ClientConfig() oauth2.ClientConfig {
+	return oauth2.ClientConfig{
+		Network: s.Network(),
+		Address: s.Addr(),
+	}
+}
+
+// AddToken makes server answer r to token with scope
+func (s *Server) AddToken(token, scope string, r oauth2.ResponseOAUTH2) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	scopes, ok := s.tokens[token]
+	if !ok {
+		scopes = make(map[string]oauth2.ResponseOAUTH2)
+		s.tokens[token] = scopes
+	}
+	scopes[scope] = r
+}
+
+// SetReturnCode makes server answer error code to token with scope
+func (s *Server) SetReturnCode(token, scope string, code int32) {
+	s.AddToken(token, scope, *oauth2.ErrorResponse(code))
+}
+
+// RemoveToken makes server answer CubeOAUTH2ErrCodeTokenNotFound to token
+func (s *Server) RemoveToken(token string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.tokens, token)
+}
+
+// SetTokens replaces token table, mapping token to scope to response
+func (s *Server) SetTokens(tokens map[string]map[string]oauth2.ResponseOAUTH2) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.tokens = tokens
+}
+
+// Requests returns number of requests answered
+func (s *Server) Requests() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.requests
+}
+
+// Conns returns number of open connections
+func (s *Server) Conns() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.conns)
+}
+
+// Close stops server and closes all connections
+func (s *Server) Close() {
+	s.lock.Lock()
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.lock.Unlock()
+	s.listener.Close()
+	s.wg.Wait()
+	if s.tempDir != "" {
+		os.RemoveAll(s.tempDir)
+	}
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.lock.Lock()
+		if s.closed {
+			s.lock.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.lock.Unlock()
+
+		s.wg.Add(1)
+		go s.serveConn(conn)
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.lock.Lock()
+		delete(s.conns, conn)
+		s.lock.Unlock()
+		conn.Close()
+	}()
+
+	d := oauth2.CreateDecoder(bufio.NewReader(conn))
+	for {
+		req := &oauth2.RequestOAUTH2{}
+		err := d.ReadOAUTH2Request(req)
+		var r *oauth2.ResponseOAUTH2
+		var fault *Fault
+		switch {
+		case err == nil:
+			r, fault = s.answer(req)
+		case errors.Is(err, oauth2.ErrUnknownMSGType):
+			r = oauth2.ErrorResponse(oauth2.CubeOAUTH2ErrCodeUnknownMSG)
+		case errors.Is(err, oauth2.ErrNotEnoughData), cubeapi.Cause(err) == nil:
+			// connection is closed or broken
+			return
+		default:
+			r = oauth2.ErrorResponse(oauth2.CubeOAUTH2ErrCodeBadPacket)
+		}
+		resp, encErr := oauth2.CreateOAUTH2Response(req.RequestID, r)
+		if encErr != nil {
+			return
+		}
+		if fault != nil {
+			written, werr := fault.write(conn, resp.Bytes())
+			if werr != nil || !written {
+				return
+			}
+			continue
+		}
+		if _, werr := conn.Write(resp.Bytes()); werr != nil {
+			return
+		}
+		if err != nil && !errors.Is(err, oauth2.ErrUnknownMSGType) {
+			// malformed request breaks framing, closing connection
+			return
+		}
+	}
+}
+
+// answer returns response to request and fault it should be answered with
+func (s *Server) answer(req *oauth2.RequestOAUTH2) (*oauth2.ResponseOAUTH2, *Fault) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.requests++
+	fault := s.nextFault(req.Token)
+	scopes, found := s.tokens[req.Token]
+	if !found {
+		return oauth2.ErrorResponse(oauth2.CubeOAUTH2ErrCodeTokenNotFound), fault
+	}
+	r, found := scopes[req.Scope]
+	if !found {
+		return oauth2.ErrorResponse(oauth2.CubeOAUTH2ErrCodeBadScope), fault
+	}
+	return &r, fault
+}
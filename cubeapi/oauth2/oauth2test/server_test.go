@@ -0,0 +1,152 @@
+package oauth2test_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Apakhov/cube/cubeapi"
+	"github.com/Apakhov/cube/cubeapi/oauth2"
+	"github.com/Apakhov/cube/cubeapi/oauth2/oauth2test"
+	"github.com/stretchr/testify/require"
+)
+
+var testResponse = oauth2.ResponseOAUTH2{
+	ReturnCode: oauth2.CubeOAUTH2ErrCodeOK,
+	CliendID:   "test_client_id",
+	ClientType: 2002,
+	Username:   "testuser@mail.ru",
+	ExpiresIn:  3600,
+	UserID:     101010,
+}
+
+func TestServer(t *testing.T) {
+	s := oauth2test.CreateServer()
+	defer s.Close()
+	s.AddToken("token", "scope", testResponse)
+	s.SetReturnCode("token", "db", oauth2.CubeOAUTH2ErrCodeDBError)
+
+	require.True(t, strings.HasPrefix(s.URL(), "tcp://127.0.0.1:"))
+	client := oauth2.CreateClient(s.ClientConfig())
+	defer client.Close()
+
+	res, err := client.Validate(context.Background(), "token", "scope")
+	require.NoError(t, err)
+	require.Equal(t, testResponse, *res)
+
+	res, err = client.Validate(context.Background(), "token", "db")
+	require.NoError(t, err)
+	require.Equal(t, oauth2.CubeOAUTH2ErrCodeDBError, res.ReturnCode)
+	require.Equal(t, oauth2.CubeOAUTH2ErrDescrDBError, res.ErrorString)
+
+	res, err = client.Validate(context.Background(), "token", "other")
+	require.NoError(t, err)
+	require.Equal(t, oauth2.CubeOAUTH2ErrCodeBadScope, res.ReturnCode)
+
+	res, err = client.Validate(context.Background(), "unknown", "scope")
+	require.NoError(t, err)
+	require.Equal(t, oauth2.CubeOAUTH2ErrCodeTokenNotFound, res.ReturnCode)
+
+	s.RemoveToken("token")
+	res, err = client.Validate(context.Background(), "token", "scope")
+	require.NoError(t, err)
+	require.Equal(t, oauth2.CubeOAUTH2ErrCodeTokenNotFound, res.ReturnCode)
+
+	require.Equal(t, 5, s.Requests())
+	require.Equal(t, int64(1), client.Stats().Dials)
+}
+
+func TestUnixServer(t *testing.T) {
+	s := oauth2test.CreateUnixServer()
+	defer s.Close()
+	s.AddToken("token", "scope", testResponse)
+
+	require.Equal(t, "unix", s.Network())
+	require.True(t, strings.HasPrefix(s.URL(), "unix:///"))
+	client := oauth2.CreateClient(s.ClientConfig())
+	defer client.Close()
+
+	res, err := client.Validate(context.Background(), "token", "scope")
+	require.NoError(t, err)
+	require.Equal(t, testResponse, *res)
+}
+
+func TestServerMultiplex(t *testing.T) {
+	s := oauth2test.CreateServer()
+	defer s.Close()
+	s.AddToken("token", "scope", testResponse)
+
+	conf := s.ClientConfig()
+	conf.Multiplex = true
+	client := oauth2.CreateClient(conf)
+	defer client.Close()
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := client.Validate(context.Background(), "token", "scope")
+			require.NoError(t, err)
+			require.Equal(t, testResponse, *res)
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, 50, s.Requests())
+}
+
+func TestServerClose(t *testing.T) {
+	s := oauth2test.CreateServer()
+	s.AddToken("token", "scope", testResponse)
+	client := oauth2.CreateClient(s.ClientConfig())
+	defer client.Close()
+
+	_, err := client.Validate(context.Background(), "token", "scope")
+	require.NoError(t, err)
+	s.Close()
+	_, err = client.Validate(context.Background(), "token", "scope")
+	require.Error(t, err)
+}
+
+func TestServerMalformedRequest(t *testing.T) {
+	s := oauth2test.CreateServer()
+	defer s.Close()
+	s.AddToken("token", "scope", testResponse)
+
+	conn, err := net.Dial(s.Network(), s.Addr())
+	require.NoError(t, err)
+	defer conn.Close()
+	d := oauth2.CreateDecoder(conn)
+
+	// unknown message type is answered, connection stays open
+	unknown := &bytes.Buffer{}
+	err = cubeapi.CreateEncoder(unknown).WriteFrame(0x2, 0x5, cubeapi.Int32(0x2), cubeapi.String("token"))
+	require.NoError(t, err)
+	_, err = conn.Write(unknown.Bytes())
+	require.NoError(t, err)
+	var res oauth2.ResponseOAUTH2
+	require.NoError(t, d.ReadOAUTH2Resp(&res))
+	require.Equal(t, oauth2.CubeOAUTH2ErrCodeUnknownMSG, res.ReturnCode)
+
+	req, err := oauth2.CreateOAUTH2Request("token", "scope")
+	require.NoError(t, err)
+	_, err = conn.Write(req.Bytes())
+	require.NoError(t, err)
+	res = oauth2.ResponseOAUTH2{}
+	require.NoError(t, d.ReadOAUTH2Resp(&res))
+	require.Equal(t, testResponse, res)
+
+	// bad packet is answered, connection is closed
+	badPacket := &bytes.Buffer{}
+	err = cubeapi.CreateEncoder(badPacket).WriteFrame(0x2, 0x6, cubeapi.Int32(0x1), cubeapi.String("token"))
+	require.NoError(t, err)
+	_, err = conn.Write(badPacket.Bytes())
+	require.NoError(t, err)
+	require.NoError(t, d.ReadOAUTH2Resp(&res))
+	require.Equal(t, oauth2.CubeOAUTH2ErrCodeBadPacket, res.ReturnCode)
+	require.True(t, errors.Is(d.ReadOAUTH2Resp(&res), oauth2.ErrNotEnoughData))
+}
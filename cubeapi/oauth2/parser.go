@@ -2,7 +2,6 @@ package oauth2
 
 import (
 	"github.com/Apakhov/cube/cubeapi"
-	"github.com/pkg/errors"
 )
 
 // RespBuffer struct for parsing oauth2 response
@@ -26,20 +25,35 @@ func (buf *RespBuffer) Finished() {
 	buf.buffer.Finished()
 }
 
-func (buf *RespBuffer) createError(err error, msg string) {
+// SetSnippetLen makes parse errors carry up to n bytes surrounding failed
+// field, see cubeapi.RespBuffer.SetSnippetLen
+func (buf *RespBuffer) SetSnippetLen(n int) {
+	buf.buffer.SetSnippetLen(n)
+}
+
+// createError keeps the first error of parsing, sentinel err occurred on
+// field at offset
+func (buf *RespBuffer) createError(err *Error, field string, offset int64) {
 	if buf.err == nil {
-		buf.err = errors.Wrap(err, msg)
+		buf.err = withOffset(err, "", field, offset)
 	}
 }
 
-func (buf *RespBuffer) checkError(msg string) (written bool) {
+// checkError takes error of parsing field from underlying buffer
+func (buf *RespBuffer) checkError(field string) (written bool) {
 	if buf.buffer.Error() != nil && buf.err == nil {
-		buf.err = switchError(buf.buffer.Error())
+		buf.err = cubeapi.WithField(buf.buffer.Error(), field)
 	}
-	buf.err = errors.Wrap(buf.err, msg)
 	return buf.err != nil
 }
 
+// wrapError annotates error of parsing with operation
+func (buf *RespBuffer) wrapError(op string) {
+	if buf.err != nil {
+		buf.err = cubeapi.WithOp(buf.err, op)
+	}
+}
+
 func (buf *RespBuffer) Error() error {
 	return buf.err
 }
@@ -58,22 +72,22 @@ func (buf *RespBuffer) WaitChan() <-chan struct{} {
 
 // ParseOAUTH2Resp parses oauth2 response
 func (buf *RespBuffer) ParseOAUTH2Resp(r *ResponseOAUTH2) {
+	defer buf.wrapError("parse OAUTH2 response")
+	start := buf.buffer.Offset()
 	h := &cubeapi.Header{}
 	buf.buffer.IncreaseParseLim(cubeapi.HeaderLen)
 	buf.buffer.ParseHeader(h)
-	buf.checkError("failed to parse OAUTH2 response")
-	if buf.err != nil {
+	if buf.checkError("") {
 		return
 	}
 	if h.SvcID != cubeOAUTH2SvcID {
-		buf.createError(ErrIncorrectSVCID, "failed to parse OAUTH2 response")
+		buf.createError(ErrIncorrectSVCID, "svc id", start)
 		return
 	}
 	buf.buffer.IncreaseParseLim(int64(h.BodyLength))
 	buf.parseOAUTH2RespBody(r)
-	buf.checkError("failed to parse OAUTH2 response")
 	if buf.err == nil && buf.buffer.GetParseLim() > 0 {
-		buf.createError(ErrIncorrectBodyLen, "failed to parse OAUTH2 response")
+		buf.createError(ErrIncorrectBodyLen, "", buf.buffer.Offset())
 		return
 	}
 	return
@@ -93,7 +107,6 @@ func (buf *RespBuffer) parseOAUTH2RespBody(r *ResponseOAUTH2) {
 		buf.parseOAUTH2ExpiresInInfo(r)
 		buf.parseOAUTH2UserID(r)
 	}
-	buf.checkError("failed to parse OAUTH2 response body")
 	return
 }
 
@@ -102,7 +115,7 @@ func (buf *RespBuffer) parseOAUTH2RespReturnCode(r *ResponseOAUTH2) {
 		return
 	}
 	buf.buffer.ParseInt32(&r.ReturnCode)
-	buf.checkError("failed to parse return code")
+	buf.checkError("return code")
 	return
 }
 
@@ -111,7 +124,7 @@ func (buf *RespBuffer) parseOAUTH2ErrString(r *ResponseOAUTH2) {
 		return
 	}
 	buf.buffer.ParseString(&r.ErrorString)
-	buf.checkError("failed to parse error string")
+	buf.checkError("error string")
 	return
 }
 
@@ -120,7 +133,7 @@ func (buf *RespBuffer) parseOAUTH2ClientID(r *ResponseOAUTH2) {
 		return
 	}
 	buf.buffer.ParseString(&r.CliendID)
-	buf.checkError("failed to parse client id")
+	buf.checkError("client id")
 	return
 }
 
@@ -129,7 +142,7 @@ func (buf *RespBuffer) parseOAUTH2ClientType(r *ResponseOAUTH2) {
 		return
 	}
 	buf.buffer.ParseInt32(&r.ClientType)
-	buf.checkError("failed to parse client type")
+	buf.checkError("client type")
 	return
 }
 
@@ -138,7 +151,7 @@ func (buf *RespBuffer) parseOAUTH2Username(r *ResponseOAUTH2) {
 		return
 	}
 	buf.buffer.ParseString(&r.Username)
-	buf.checkError("failed to parse username")
+	buf.checkError("username")
 	return
 }
 
@@ -147,7 +160,7 @@ func (buf *RespBuffer) parseOAUTH2ExpiresInInfo(r *ResponseOAUTH2) {
 		return
 	}
 	buf.buffer.ParseInt32(&r.ExpiresIn)
-	buf.checkError("failed to parse expires_in data")
+	buf.checkError("expires in")
 	return
 }
 
@@ -156,6 +169,65 @@ func (buf *RespBuffer) parseOAUTH2UserID(r *ResponseOAUTH2) {
 		return
 	}
 	buf.buffer.ParseInt64(&r.UserID)
-	buf.checkError("failed to parse user id")
+	buf.checkError("user id")
+	return
+}
+
+// ParseOAUTH2Request parses oauth2 request. RequestID is set even if request
+// is malformed, so it can be answered
+func (buf *RespBuffer) ParseOAUTH2Request(r *RequestOAUTH2) {
+	defer buf.wrapError("parse OAUTH2 request")
+	start := buf.buffer.Offset()
+	h := &cubeapi.Header{}
+	buf.buffer.IncreaseParseLim(cubeapi.HeaderLen)
+	buf.buffer.ParseHeader(h)
+	if buf.checkError("") {
+		return
+	}
+	r.RequestID = h.RequestID
+	if h.SvcID != cubeOAUTH2SvcID {
+		buf.createError(ErrIncorrectSVCID, "svc id", start)
+		return
+	}
+	buf.buffer.IncreaseParseLim(int64(h.BodyLength))
+	buf.parseOAUTH2ReqBody(r)
+	if buf.err == nil && buf.buffer.GetParseLim() > 0 {
+		buf.createError(ErrIncorrectBodyLen, "", buf.buffer.Offset())
+		return
+	}
+	return
+}
+
+func (buf *RespBuffer) parseOAUTH2ReqBody(r *RequestOAUTH2) {
+	if buf.err != nil {
+		return
+	}
+	var msg int32
+	offset := buf.buffer.Offset()
+	buf.buffer.ParseInt32(&msg)
+	buf.checkError("svc message type")
+	if buf.err == nil && msg != cubeOAUTH2SvcMSG {
+		buf.createError(ErrUnknownMSGType, "svc message type", offset)
+	}
+	buf.parseOAUTH2Token(r)
+	buf.parseOAUTH2Scope(r)
+	return
+}
+
+func (buf *RespBuffer) parseOAUTH2Token(r *RequestOAUTH2) {
+	if buf.err != nil {
+		return
+	}
+	buf.buffer.ParseString(&r.Token)
+	buf.checkError("token")
+	return
+}
+
+func (buf *RespBuffer) parseOAUTH2Scope(r *RequestOAUTH2) {
+	if buf.err != nil {
+		return
+	}
+	buf.buffer.ParseString(&r.Scope)
+	buf.checkError("scope")
 	return
 }
@@ -2,14 +2,15 @@ package oauth2_test
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"reflect"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/Apakhov/cube/cubeapi"
 	"github.com/Apakhov/cube/cubeapi/oauth2"
-	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
 )
 
@@ -204,6 +205,8 @@ type parseOAUTH2RespErrCase struct {
 	bytes  []byte
 	err    error
 	blCorr bool
+	// decErr is error expected from Decoder if it differs from err
+	decErr error
 }
 
 var parseOAUTH2RespErrCases = []parseOAUTH2RespErrCase{
@@ -213,7 +216,8 @@ var parseOAUTH2RespErrCases = []parseOAUTH2RespErrCase{
 			buildInt32(0x1), // incorrect body length
 			buildInt32(0x1),
 		),
-		err: oauth2.ErrIncorrectLen,
+		err:    oauth2.ErrIncorrectLen,
+		decErr: oauth2.ErrNotEnoughData,
 	},
 	{
 		bytes: []byte{}, // no header
@@ -325,7 +329,7 @@ func TestParseOAUTH2RespErr(t *testing.T) {
 		buf.ParseOAUTH2Resp(&res)
 
 		err := buf.Error()
-		if err == nil || errors.Cause(err) != c.err {
+		if err == nil || !errors.Is(err, c.err) {
 			errStr := ""
 			if err != nil {
 				errStr = err.Error()
@@ -334,3 +338,122 @@ func TestParseOAUTH2RespErr(t *testing.T) {
 		}
 	}
 }
+
+func TestParseOAUTH2Request(t *testing.T) {
+	req, err := oauth2.CreateOAUTH2Request("token", "scope")
+	require.NoError(t, err)
+	req.SetRequestID(0x7)
+
+	buf := oauth2.CreateRespBuffer(req.Bytes())
+	buf.Finished()
+	var res oauth2.RequestOAUTH2
+	buf.ParseOAUTH2Request(&res)
+	require.NoError(t, buf.Error())
+	require.Equal(t, oauth2.RequestOAUTH2{RequestID: 0x7, Token: "token", Scope: "scope"}, res)
+}
+
+var parseOAUTH2RequestErrCases = []parseOAUTH2RespErrCase{
+	{
+		bytes: flat(
+			buildInt32(0x43534534), // incorrect svcID
+			buildInt32(0x0),
+			buildInt32(0x7),
+		),
+		err: oauth2.ErrIncorrectSVCID,
+	},
+	{
+		bytes: flat(
+			buildInt32(0x2),
+			buildInt32(0x0),
+			buildInt32(0x7),
+			buildInt32(0x2), // unknown message type
+			buildString("token"),
+			buildString("scope"),
+		),
+		err:    oauth2.ErrUnknownMSGType,
+		blCorr: true,
+	},
+	{
+		bytes: flat(
+			buildInt32(0x2),
+			buildInt32(0x0),
+			buildInt32(0x7),
+			buildInt32(0x1),
+			buildString("token"), // no scope
+		),
+		err:    oauth2.ErrIncorrectLen,
+		blCorr: true,
+	},
+	{
+		bytes: flat(
+			buildInt32(0x2),
+			buildInt32(0x0),
+			buildInt32(0x7),
+			buildInt32(0x1),
+			buildString("token"),
+			buildString("scope"),
+			buildInt32(0x0), // extra data
+		),
+		err:    oauth2.ErrIncorrectBodyLen,
+		blCorr: true,
+	},
+}
+
+func TestParseOAUTH2RequestErr(t *testing.T) {
+	for i, c := range parseOAUTH2RequestErrCases {
+		testBytes := append([]byte{}, c.bytes...)
+		if c.blCorr {
+			// inserting body length
+			binary.LittleEndian.PutUint32(testBytes[4:8], uint32(len(testBytes)-12))
+		}
+		buf := oauth2.CreateRespBuffer(testBytes)
+		buf.Finished()
+		var res oauth2.RequestOAUTH2
+		buf.ParseOAUTH2Request(&res)
+
+		err := buf.Error()
+		require.True(t, errors.Is(err, c.err), fmt.Sprintf("%d expected error ", i))
+		require.Equal(t, int32(0x7), res.RequestID, fmt.Sprintf("%d expected request id ", i))
+	}
+}
+
+func TestParseOAUTH2RespErrContext(t *testing.T) {
+	testBytes := []byte{}
+	testBytes = append(testBytes, buildInt32(0x2)...)    // svc id
+	testBytes = append(testBytes, buildInt32(0xb)...)    // body len
+	testBytes = append(testBytes, buildInt32(0x1)...)    // request id
+	testBytes = append(testBytes, buildInt32(0x1)...)    // return code
+	testBytes = append(testBytes, buildString("bad")...) // error string
+	// second frame starts at 23
+	testBytes = append(testBytes, buildInt32(0x2)...)  // svc id
+	testBytes = append(testBytes, buildInt32(39)...)   // body len
+	testBytes = append(testBytes, buildInt32(0x2)...)  // request id
+	testBytes = append(testBytes, buildInt32(0x0)...)  // return code
+	testBytes = append(testBytes, buildString("c")...) // client id
+	testBytes = append(testBytes, buildInt32(2002)...) // client type
+	testBytes = append(testBytes, buildInt32(10)...)   // username length
+	testBytes = append(testBytes, "abc"...)            // truncated username
+
+	buf := oauth2.CreateRespBuffer(testBytes)
+	buf.SetSnippetLen(4)
+	buf.Finished()
+	var res oauth2.ResponseOAUTH2
+	buf.ParseOAUTH2Resp(&res)
+	require.NoError(t, buf.Error())
+	buf.ParseOAUTH2Resp(&res)
+
+	err := buf.Error()
+	require.True(t, errors.Is(err, cubeapi.ErrNotEnoughData))
+	var e *oauth2.Error
+	require.True(t, errors.As(err, &e))
+	require.Equal(t, "username", e.Field)
+	require.Equal(t, int64(48), e.Offset)
+	require.Equal(t, int64(10), e.Expected)
+	require.Equal(t, int64(3), e.Available)
+	require.Equal(t, []byte{10, 0, 0, 0, 'a', 'b', 'c'}, e.Snippet)
+	require.Equal(t, int64(48), e.SnippetOffset)
+	require.Equal(t, "00000030  0a 00 00 00 61 62 63\n", e.HexSnippet())
+	require.Equal(t,
+		`parse OAUTH2 response: parse string "username" at offset 48 (expected 10 bytes, 3 available): Not enough data`,
+		err.Error())
+}
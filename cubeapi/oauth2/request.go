@@ -2,7 +2,6 @@ package oauth2
 
 import (
 	"github.com/Apakhov/cube/cubeapi"
-	"github.com/pkg/errors"
 )
 
 // SendBuffer struct for encoding oauth2 request
@@ -15,13 +14,17 @@ func (buf *SendBuffer) Bytes() []byte {
 	return buf.buffer.Bytes()
 }
 
+// SetRequestID sets RequestID of request
+func (buf *SendBuffer) SetRequestID(requestID int32) {
+	buf.buffer.SetRequestID(requestID)
+}
+
 // CreateOAUTH2Request creates request based on tocken and scope
 func CreateOAUTH2Request(token, scope string) (*SendBuffer, error) {
 	buf := &SendBuffer{cubeapi.CreateSendBuffer()}
 	bodyLen, err := buf.writeOAUTH2Body(token, scope)
 	if err != nil {
-		err = errors.Wrap(switchError(err), "failed to write request body")
-		return nil, err
+		return nil, cubeapi.WithOp(err, "write OAUTH2 request")
 	}
 
 	buf.buffer.WriteHeader(cubeOAUTH2SvcID, bodyLen)
@@ -34,12 +37,12 @@ func (buf *SendBuffer) writeOAUTH2Body(token, scope string) (bodyLen int32, err
 
 	err = buf.buffer.WriteString(token)
 	if err != nil {
-		err = errors.Wrap(switchError(err), "can't write to buffer")
+		err = cubeapi.WithField(err, "token")
 		return
 	}
 	err = buf.buffer.WriteString(scope)
 	if err != nil {
-		err = errors.Wrap(switchError(err), "can't write to buffer")
+		err = cubeapi.WithField(err, "scope")
 		return
 	}
 	bodyLen = int32(buf.buffer.Len() - headerLen)
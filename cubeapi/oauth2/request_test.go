@@ -2,11 +2,11 @@ package oauth2_test
 
 import (
 	"bytes"
+	"errors"
 	"math"
 	"testing"
 
 	"github.com/Apakhov/cube/cubeapi/oauth2"
-	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
 )
 
@@ -25,16 +25,16 @@ func TestCreateOAUTH2Request(t *testing.T) {
 
 func TestCreateOAUTH2RequestErr(t *testing.T) {
 	_, err := oauth2.CreateOAUTH2Request(string(make([]byte, int64(math.MaxInt32)+1, int64(math.MaxInt32)+1)), "test")
-	if err == nil || errors.Cause(err) != oauth2.ErrStringTooLong {
+	if err == nil || !errors.Is(err, oauth2.ErrStringTooLong) {
 		errStr := ""
 		if err != nil {
 			errStr = err.Error()
 		}
-		t.Logf("%+v <-> %+v", errors.Cause(err), oauth2.ErrStringTooLong)
+		t.Logf("%+v <-> %+v", err, oauth2.ErrStringTooLong)
 		require.Equal(t, oauth2.ErrStringTooLong.Error(), errStr, "expected error 1")
 	}
 	_, err = oauth2.CreateOAUTH2Request("test", string(make([]byte, int64(math.MaxInt32)+1, int64(math.MaxInt32)+1)))
-	if err == nil || errors.Cause(err) != oauth2.ErrStringTooLong {
+	if err == nil || !errors.Is(err, oauth2.ErrStringTooLong) {
 		errStr := ""
 		if err != nil {
 			errStr = err.Error()
@@ -0,0 +1,79 @@
+package oauth2
+
+import (
+	"github.com/Apakhov/cube/cubeapi"
+)
+
+// CreateOAUTH2Response creates response to request with requestID. Error
+// string is written instead of token info if return code is not
+// CubeOAUTH2ErrCodeOK
+func CreateOAUTH2Response(requestID int32, r *ResponseOAUTH2) (*SendBuffer, error) {
+	buf := &SendBuffer{cubeapi.CreateSendBuffer()}
+	bodyLen, err := buf.writeOAUTH2RespBody(r)
+	if err != nil {
+		return nil, cubeapi.WithOp(err, "write OAUTH2 response")
+	}
+
+	buf.buffer.WriteHeader(cubeOAUTH2SvcID, bodyLen)
+	buf.buffer.SetRequestID(requestID)
+	return buf, nil
+}
+
+func (buf *SendBuffer) writeOAUTH2RespBody(r *ResponseOAUTH2) (bodyLen int32, err error) {
+	headerLen := buf.buffer.Len()
+	buf.buffer.WriteInt32(r.ReturnCode)
+
+	if r.ReturnCode != CubeOAUTH2ErrCodeOK {
+		err = buf.buffer.WriteString(r.ErrorString)
+		if err != nil {
+			err = cubeapi.WithField(err, "error string")
+			return
+		}
+		bodyLen = int32(buf.buffer.Len() - headerLen)
+		return
+	}
+
+	err = buf.buffer.WriteString(r.CliendID)
+	if err != nil {
+		err = cubeapi.WithField(err, "client id")
+		return
+	}
+	buf.buffer.WriteInt32(r.ClientType)
+	err = buf.buffer.WriteString(r.Username)
+	if err != nil {
+		err = cubeapi.WithField(err, "username")
+		return
+	}
+	buf.buffer.WriteInt32(r.ExpiresIn)
+	buf.buffer.WriteInt64(r.UserID)
+	bodyLen = int32(buf.buffer.Len() - headerLen)
+	return
+}
+
+// responseFields returns fields of response body for Encoder
+func responseFields(r *ResponseOAUTH2) []cubeapi.Field {
+	if r.ReturnCode != CubeOAUTH2ErrCodeOK {
+		return []cubeapi.Field{
+			cubeapi.Int32(r.ReturnCode),
+			cubeapi.String(r.ErrorString),
+		}
+	}
+	return []cubeapi.Field{
+		cubeapi.Int32(r.ReturnCode),
+		cubeapi.String(r.CliendID),
+		cubeapi.Int32(r.ClientType),
+		cubeapi.String(r.Username),
+		cubeapi.Int32(r.ExpiresIn),
+		cubeapi.Int64(r.UserID),
+	}
+}
+
+// ErrorResponse returns response with error code and its description as
+// error string
+func ErrorResponse(code int32) *ResponseOAUTH2 {
+	descr, _ := errInfoByCode(code)
+	return &ResponseOAUTH2{
+		ReturnCode:  code,
+		ErrorString: descr,
+	}
+}
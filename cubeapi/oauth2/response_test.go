@@ -0,0 +1,75 @@
+package oauth2_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/Apakhov/cube/cubeapi/oauth2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateOAUTH2Response(t *testing.T) {
+	r := &oauth2.ResponseOAUTH2{
+		ReturnCode: oauth2.CubeOAUTH2ErrCodeOK,
+		CliendID:   "test_client_id",
+		ClientType: 2002,
+		Username:   "testuser@mail.ru",
+		ExpiresIn:  3600,
+		UserID:     101010,
+	}
+	buf, err := oauth2.CreateOAUTH2Response(0x0, r)
+	require.NoError(t, err)
+	require.Equal(t, okResponse(), buf.Bytes())
+
+	var res oauth2.ResponseOAUTH2
+	require.NoError(t, oauth2.CreateDecoder(bytes.NewReader(buf.Bytes())).ReadOAUTH2Resp(&res))
+	require.Equal(t, *r, res)
+}
+
+func TestCreateOAUTH2ResponseErrCode(t *testing.T) {
+	exp := flat(
+		buildInt32(0x2),
+		buildInt32(0x0),
+		buildInt32(0x7),
+		buildInt32(oauth2.CubeOAUTH2ErrCodeBadClient),
+		buildString("lol you died"),
+	)
+	binary.LittleEndian.PutUint32(exp[4:8], uint32(len(exp)-12))
+
+	r := &oauth2.ResponseOAUTH2{
+		ReturnCode:  oauth2.CubeOAUTH2ErrCodeBadClient,
+		CliendID:    "ignored",
+		ErrorString: "lol you died",
+	}
+	buf, err := oauth2.CreateOAUTH2Response(0x7, r)
+	require.NoError(t, err)
+	require.Equal(t, exp, buf.Bytes())
+
+	res := oauth2.ResponseOAUTH2{}
+	parser := oauth2.CreateRespBuffer(buf.Bytes())
+	parser.Finished()
+	parser.ParseOAUTH2Resp(&res)
+	require.NoError(t, parser.Error())
+	require.Equal(t, oauth2.ResponseOAUTH2{ReturnCode: r.ReturnCode, ErrorString: r.ErrorString}, res)
+}
+
+func TestWriteOAUTH2Response(t *testing.T) {
+	for _, r := range []*oauth2.ResponseOAUTH2{
+		{ReturnCode: oauth2.CubeOAUTH2ErrCodeOK, CliendID: "id", Username: "user", UserID: 1},
+		oauth2.ErrorResponse(oauth2.CubeOAUTH2ErrCodeBadScope),
+	} {
+		buf, err := oauth2.CreateOAUTH2Response(0x7, r)
+		require.NoError(t, err)
+
+		w := &bytes.Buffer{}
+		require.NoError(t, oauth2.CreateEncoder(w).WriteOAUTH2Response(0x7, r))
+		require.Equal(t, buf.Bytes(), w.Bytes())
+	}
+}
+
+func TestErrorResponse(t *testing.T) {
+	r := oauth2.ErrorResponse(oauth2.CubeOAUTH2ErrCodeTokenNotFound)
+	require.Equal(t, oauth2.CubeOAUTH2ErrCodeTokenNotFound, r.ReturnCode)
+	require.Equal(t, oauth2.CubeOAUTH2ErrDescrTokenNotFound, r.ErrorString)
+}
@@ -0,0 +1,105 @@
+package oauth2
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// default backoff of RetryPolicy
+const (
+	DefaultBaseBackoff = 50 * time.Millisecond
+	DefaultMaxBackoff  = time.Second
+)
+
+// RetryPolicy configures retries of validations failed with transient
+// errors. Answers with return codes other than CubeOAUTH2ErrCodeDBError
+// are definitive and never retried, as well as cancelled validations
+type RetryPolicy struct {
+	// MaxAttempts limits number of attempts including the first one,
+	// validation isn't retried if it is less than 2
+	MaxAttempts int
+	// BaseBackoff is delay before the first retry, it doubles with every
+	// next one. DefaultBaseBackoff if zero
+	BaseBackoff time.Duration
+	// MaxBackoff caps delay between attempts, DefaultMaxBackoff if zero
+	MaxBackoff time.Duration
+	// Jitter is fraction of delay chosen randomly, from 0 to 1. Delay d is
+	// uniformly distributed in [d*(1-Jitter), d]
+	Jitter float64
+	// RetryOn reports whether failed attempt should be retried, IsRetryable
+	// if nil. Error response is passed as ReturnCodeError
+	RetryOn func(err error) bool
+}
+
+// retry calls validate until it succeeds, fails with error not approved by
+// policy or runs out of attempts. Retry which wouldn't start before
+// deadline of ctx isn't made, result of the last attempt is returned
+func (p *RetryPolicy) retry(ctx context.Context,
+	validate func(ctx context.Context) (*ResponseOAUTH2, error)) (*ResponseOAUTH2, error) {
+	for attempt := 1; ; attempt++ {
+		r, err := validate(ctx)
+		if attempt >= p.MaxAttempts || !p.retryable(r, err) {
+			return r, err
+		}
+
+		delay := p.backoff(attempt)
+		if deadline, ok := ctx.Deadline(); ok && !time.Now().Add(delay).Before(deadline) {
+			return r, err
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// retryable reports whether result of attempt should be retried
+func (p *RetryPolicy) retryable(r *ResponseOAUTH2, err error) bool {
+	if err == nil {
+		if err = ErrorOf(r); err == nil {
+			return false
+		}
+	}
+	var e *ReturnCodeError
+	if errors.As(err, &e) && !e.Retryable() {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if p.RetryOn != nil {
+		return p.RetryOn(err)
+	}
+	return IsRetryable(err)
+}
+
+// backoff returns delay before retry following attempt
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	base, max := p.BaseBackoff, p.MaxBackoff
+	if base <= 0 {
+		base = DefaultBaseBackoff
+	}
+	if max <= 0 {
+		max = DefaultMaxBackoff
+	}
+	delay := base
+	for i := 1; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	if p.Jitter > 0 {
+		jitter := p.Jitter
+		if jitter > 1 {
+			jitter = 1
+		}
+		delay -= time.Duration(rand.Float64() * jitter * float64(delay))
+	}
+	return delay
+}
@@ -0,0 +1,141 @@
+package oauth2_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Apakhov/cube/cubeapi/oauth2"
+	"github.com/Apakhov/cube/cubeapi/oauth2/oauth2test"
+	"github.com/stretchr/testify/require"
+)
+
+func startRetryServer(policy oauth2.RetryPolicy) (*oauth2test.Server, *oauth2.Client) {
+	s := oauth2test.CreateServer()
+	s.AddToken("token", "scope", oauth2.ResponseOAUTH2{Username: "testuser@mail.ru"})
+	conf := s.ClientConfig()
+	conf.Retry = policy
+	return s, oauth2.CreateClient(conf)
+}
+
+func TestRetryTransportError(t *testing.T) {
+	s, client := startRetryServer(oauth2.RetryPolicy{MaxAttempts: 3, BaseBackoff: time.Millisecond})
+	defer s.Close()
+	defer client.Close()
+
+	s.SetFaults(oauth2test.Fault{Times: 2, Close: true})
+	r, err := client.Validate(context.Background(), "token", "scope")
+	require.NoError(t, err)
+	require.Equal(t, "testuser@mail.ru", r.Username)
+	require.Equal(t, 3, s.Requests())
+
+	// failure of reused connection is retried inside attempt
+	s.SetFaults(oauth2test.Fault{Close: true})
+	_, err = client.Validate(context.Background(), "token", "scope")
+	require.True(t, errors.Is(err, oauth2.ErrNotEnoughData))
+	require.Equal(t, 7, s.Requests())
+}
+
+func TestRetryReturnCodes(t *testing.T) {
+	s, client := startRetryServer(oauth2.RetryPolicy{MaxAttempts: 3, BaseBackoff: time.Millisecond})
+	defer s.Close()
+	defer client.Close()
+
+	// definitive answers aren't retried
+	r, err := client.Validate(context.Background(), "unknown", "scope")
+	require.NoError(t, err)
+	require.Equal(t, oauth2.CubeOAUTH2ErrCodeTokenNotFound, r.ReturnCode)
+	r, err = client.Validate(context.Background(), "token", "other")
+	require.NoError(t, err)
+	require.Equal(t, oauth2.CubeOAUTH2ErrCodeBadScope, r.ReturnCode)
+	require.Equal(t, 2, s.Requests())
+
+	s.SetReturnCode("dberror", "scope", oauth2.CubeOAUTH2ErrCodeDBError)
+	r, err = client.Validate(context.Background(), "dberror", "scope")
+	require.NoError(t, err)
+	require.Equal(t, oauth2.CubeOAUTH2ErrCodeDBError, r.ReturnCode)
+	require.Equal(t, 5, s.Requests())
+}
+
+func TestRetryOn(t *testing.T) {
+	var seen []error
+	s, client := startRetryServer(oauth2.RetryPolicy{
+		MaxAttempts: 3,
+		BaseBackoff: time.Millisecond,
+		RetryOn: func(err error) bool {
+			seen = append(seen, err)
+			return false
+		},
+	})
+	defer s.Close()
+	defer client.Close()
+
+	s.SetReturnCode("dberror", "scope", oauth2.CubeOAUTH2ErrCodeDBError)
+	r, err := client.Validate(context.Background(), "dberror", "scope")
+	require.NoError(t, err)
+	require.Equal(t, oauth2.CubeOAUTH2ErrCodeDBError, r.ReturnCode)
+	require.Equal(t, 1, s.Requests())
+	require.Len(t, seen, 1)
+	require.True(t, errors.Is(seen[0], oauth2.ErrDBError))
+
+	// predicate can't make definitive answers retryable
+	conf := s.ClientConfig()
+	conf.Retry = oauth2.RetryPolicy{
+		MaxAttempts: 3,
+		RetryOn:     func(err error) bool { return true },
+	}
+	client = oauth2.CreateClient(conf)
+	defer client.Close()
+	_, err = client.Validate(context.Background(), "unknown", "scope")
+	require.NoError(t, err)
+	require.Equal(t, 2, s.Requests())
+}
+
+func TestRetryDeadline(t *testing.T) {
+	s, client := startRetryServer(oauth2.RetryPolicy{MaxAttempts: 5, BaseBackoff: time.Second})
+	defer s.Close()
+	defer client.Close()
+
+	s.SetFaults(oauth2test.Fault{Close: true})
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := client.Validate(ctx, "token", "scope")
+	require.True(t, errors.Is(err, oauth2.ErrNotEnoughData))
+	require.True(t, time.Since(start) < 200*time.Millisecond)
+	require.Equal(t, 1, s.Requests())
+
+	// cancellation interrupts backoff
+	s, client = startRetryServer(oauth2.RetryPolicy{MaxAttempts: 5, BaseBackoff: time.Second})
+	defer s.Close()
+	defer client.Close()
+	s.SetFaults(oauth2test.Fault{Close: true})
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start = time.Now()
+	_, err = client.Validate(ctx, "token", "scope")
+	require.Equal(t, context.Canceled, err)
+	require.True(t, time.Since(start) < time.Second)
+	require.Equal(t, 1, s.Requests())
+}
+
+func TestRetryJitter(t *testing.T) {
+	s, client := startRetryServer(oauth2.RetryPolicy{
+		MaxAttempts: 4,
+		BaseBackoff: 20 * time.Millisecond,
+		MaxBackoff:  30 * time.Millisecond,
+		Jitter:      0.5,
+	})
+	defer s.Close()
+	defer client.Close()
+
+	s.SetFaults(oauth2test.Fault{Times: 3, Close: true})
+	start := time.Now()
+	_, err := client.Validate(context.Background(), "token", "scope")
+	require.NoError(t, err)
+	// delays are 20, 30, 30ms capped, at least half of them is waited
+	elapsed := time.Since(start)
+	require.True(t, elapsed >= 40*time.Millisecond, elapsed.String())
+	require.True(t, elapsed < time.Second, elapsed.String())
+}
@@ -0,0 +1,159 @@
+package oauth2_test
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Apakhov/cube/cubeapi/oauth2"
+	"github.com/Apakhov/cube/cubeapi/oauth2/oauth2test"
+	"github.com/stretchr/testify/require"
+)
+
+// startTLSServer starts TLS server answering "token" with scope
+func startTLSServer(conf *tls.Config) *oauth2test.Server {
+	s := oauth2test.CreateTLSServer(conf)
+	s.AddToken("token", "scope", oauth2.ResponseOAUTH2{Username: "testuser@mail.ru"})
+	return s
+}
+
+func TestClientTLS(t *testing.T) {
+	certs := oauth2test.CreateCertificates()
+	s := startTLSServer(certs.ServerTLSConfig(false))
+	defer s.Close()
+
+	for _, multiplex := range []bool{false, true} {
+		conf := s.ClientConfig()
+		conf.TLS = certs.ClientTLSConfig(false)
+		conf.Multiplex = multiplex
+		client := oauth2.CreateClient(conf)
+		for i := 0; i < 2; i++ {
+			r, err := client.Validate(context.Background(), "token", "scope")
+			require.NoError(t, err, "multiplex %v", multiplex)
+			require.Equal(t, "testuser@mail.ru", r.Username)
+		}
+		client.Close()
+	}
+	require.Equal(t, 4, s.Requests())
+
+	// server isn't trusted without CA
+	conf := s.ClientConfig()
+	conf.TLS = &tls.Config{}
+	client := oauth2.CreateClient(conf)
+	defer client.Close()
+	_, err := client.Validate(context.Background(), "token", "scope")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "TLS handshake")
+	require.Equal(t, 4, s.Requests())
+}
+
+func TestClientMutualTLS(t *testing.T) {
+	certs := oauth2test.CreateCertificates()
+	s := startTLSServer(certs.ServerTLSConfig(true))
+	defer s.Close()
+
+	conf := s.ClientConfig()
+	conf.TLS = certs.ClientTLSConfig(true)
+	client := oauth2.CreateClient(conf)
+	r, err := client.Validate(context.Background(), "token", "scope")
+	client.Close()
+	require.NoError(t, err)
+	require.Equal(t, "testuser@mail.ru", r.Username)
+
+	// server rejects client without certificate
+	conf.TLS = certs.ClientTLSConfig(false)
+	client = oauth2.CreateClient(conf)
+	defer client.Close()
+	_, err = client.Validate(context.Background(), "token", "scope")
+	require.Error(t, err)
+	require.Equal(t, 1, s.Requests())
+}
+
+func TestClientTLSServerName(t *testing.T) {
+	certs := oauth2test.CreateCertificates("cube.test")
+	s := startTLSServer(certs.ServerTLSConfig(false))
+	defer s.Close()
+
+	// certificate isn't valid for ip address
+	conf := s.ClientConfig()
+	conf.TLS = certs.ClientTLSConfig(false)
+	client := oauth2.CreateClient(conf)
+	_, err := client.Validate(context.Background(), "token", "scope")
+	client.Close()
+	require.Error(t, err)
+
+	conf.TLS.ServerName = "cube.test"
+	client = oauth2.CreateClient(conf)
+	_, err = client.Validate(context.Background(), "token", "scope")
+	client.Close()
+	require.NoError(t, err)
+
+	// server name is host of resolved endpoint
+	certs = oauth2test.CreateCertificates("localhost")
+	s = startTLSServer(certs.ServerTLSConfig(false))
+	defer s.Close()
+	_, port, err := net.SplitHostPort(s.Addr())
+	require.NoError(t, err)
+	client = oauth2.CreateClient(oauth2.ClientConfig{
+		Endpoints: []string{net.JoinHostPort("localhost", port)},
+		Resolve:   true,
+		TLS:       certs.ClientTLSConfig(false),
+	})
+	defer client.Close()
+	_, err = client.Validate(context.Background(), "token", "scope")
+	require.NoError(t, err)
+}
+
+func TestClientTLSMinVersion(t *testing.T) {
+	certs := oauth2test.CreateCertificates()
+	serverConf := certs.ServerTLSConfig(false)
+	serverConf.MaxVersion = tls.VersionTLS12
+	s := startTLSServer(serverConf)
+	defer s.Close()
+
+	conf := s.ClientConfig()
+	conf.TLS = certs.ClientTLSConfig(false)
+	conf.TLS.MinVersion = tls.VersionTLS13
+	client := oauth2.CreateClient(conf)
+	defer client.Close()
+	_, err := client.Validate(context.Background(), "token", "scope")
+	require.Error(t, err)
+	require.Equal(t, 0, s.Requests())
+}
+
+func TestClientMuxDialBlackholed(t *testing.T) {
+	certs := oauth2test.CreateCertificates()
+	s := startTLSServer(certs.ServerTLSConfig(false))
+	defer s.Close()
+	// listener accepting connections without TLS handshake
+	blackhole, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer blackhole.Close()
+
+	client := oauth2.CreateClient(oauth2.ClientConfig{
+		Endpoints: []string{blackhole.Addr().String(), s.Addr()},
+		Multiplex: true,
+		TLS:       certs.ClientTLSConfig(false),
+	})
+	defer client.Close()
+
+	hung := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_, err := client.Validate(ctx, "token", "scope")
+		hung <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// request to healthy endpoint isn't blocked by handshake with other one
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = client.Validate(ctx, "token", "scope")
+	require.NoError(t, err)
+	require.True(t, time.Since(start) < 300*time.Millisecond, time.Since(start).String())
+	require.Equal(t, context.DeadlineExceeded, <-hung)
+}
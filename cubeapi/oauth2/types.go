@@ -15,9 +15,19 @@ type ResponseOAUTH2 struct {
 	ErrorString string
 }
 
+// RequestOAUTH2 represents oauth2 request
+type RequestOAUTH2 struct {
+	RequestID int32
+	Token     string
+	Scope     string
+}
+
 const cubeOAUTH2SvcID = int32(0x00000002)
 const cubeOAUTH2SvcMSG = int32(0x00000001)
 
+// SvcID is svc id of OAUTH2 service
+const SvcID = cubeOAUTH2SvcID
+
 // codes of errors
 const (
 	CubeOAUTH2ErrCodeOK = int32(iota)
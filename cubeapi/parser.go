@@ -3,35 +3,39 @@ package cubeapi
 import (
 	"bytes"
 	"encoding/binary"
-
-	"github.com/pkg/errors"
+	"fmt"
+	"sync"
 )
 
 // RespBuffer struct for parsing data. It can be used in sync and async mode
 //
 // sync mode - straightforward using of Parse commands
 //
-// async mode - using Parse commands in separate go-routine
+// async mode - using Parse commands in separate go-routine, parsing
+// go-routine sleeps until Write or Finished provides data
 type RespBuffer struct {
 	buffer         *bytes.Buffer
 	bytesAvailable int64
 	parseLimit     int64
-	finished       chan struct{}
+	offset         int64
+	snippetLen     int
+	recent         []byte
+	finished       bool
 	end            chan struct{}
 	err            error
-	finLock        chan struct{}
+	lock           sync.Mutex
+	dataCond       *sync.Cond
 }
 
 // CreateRespBuffer creates RespBuffer
 func CreateRespBuffer(buf []byte) *RespBuffer {
 	res := &RespBuffer{
 		buffer:         bytes.NewBuffer(buf),
-		finished:       make(chan struct{}, 1),
 		end:            make(chan struct{}, 1),
-		finLock:        make(chan struct{}, 1),
 		bytesAvailable: int64(len(buf)),
 		parseLimit:     0,
 	}
+	res.dataCond = sync.NewCond(&res.lock)
 	return res
 }
 
@@ -46,41 +50,71 @@ func (buf *RespBuffer) GetParseLim() int64 {
 }
 
 func (buf *RespBuffer) Write(part []byte) {
-	buf.finLock <- struct{}{}
+	buf.lock.Lock()
 	buf.buffer.Write(part)
 	buf.bytesAvailable += int64(len(part))
-	<-buf.finLock
+	buf.lock.Unlock()
+	buf.dataCond.Broadcast()
 }
 
 // Finished should be called after writing all data
 func (buf *RespBuffer) Finished() {
-	buf.finLock <- struct{}{}
-	buf.finished <- struct{}{}
-	<-buf.finLock
+	buf.lock.Lock()
+	buf.finished = true
+	buf.lock.Unlock()
+	buf.dataCond.Broadcast()
+}
+
+// SetSnippetLen makes parse errors carry up to n bytes preceding and
+// following failed field in Snippet, 0 disables snippets. It should be
+// called before parsing
+func (buf *RespBuffer) SetSnippetLen(n int) {
+	buf.snippetLen = n
 }
 
-func (buf *RespBuffer) createError(err error, msg string) {
-	if buf.err == nil {
-		buf.err = errors.Wrap(err, msg)
+// createError keeps the first error of parsing, err occurred in op on field
+// at offset
+func (buf *RespBuffer) createError(err error, op string, offset int64) {
+	buf.createLenError(err, op, offset, 0, 0)
+}
+
+// createLenError keeps the first error of parsing, err occurred in op on
+// field at offset which needed length bytes while available ones were left
+func (buf *RespBuffer) createLenError(err error, op string, offset, length, available int64) {
+	if buf.err != nil {
+		return
 	}
+	e := wrapError(op, offset, err)
+	e.Expected, e.Available = length, available
+	if buf.snippetLen > 0 {
+		e.Snippet, e.SnippetOffset = buf.snippet()
+	}
+	buf.err = e
 }
 
-func (buf *RespBuffer) primalErrorCheck(length int64, msg string) (ok bool) {
+// primalErrorCheck checks limit and waits for length bytes, returning them
+func (buf *RespBuffer) primalErrorCheck(length int64, op string, offset int64) (data []byte, ok bool) {
 	buf.parseLimit -= length
 	if buf.parseLimit < 0 {
+		available := buf.parseLimit + length
 		buf.parseLimit = 0
-		buf.createError(ErrIncorrectLen, msg)
-		return false
+		buf.createLenError(ErrIncorrectLen, op, offset, length, available)
+		return nil, false
+	}
+	if buf.err != nil {
+		return nil, false
 	}
-	if buf.err != nil || !buf.blockForBytes(length) {
-		buf.createError(ErrNotEnoughData, msg)
-		return false
+	data, available, ok := buf.blockForBytes(length)
+	if !ok {
+		buf.createLenError(ErrNotEnoughData, op, offset, length, available)
+		return nil, false
 	}
-	return true
+	buf.offset += length
+	return data, true
 }
 
-func (buf *RespBuffer) loadError(msg string) (written bool) {
-	buf.err = errors.Wrap(buf.err, msg)
+// loadError reports whether parsing failed
+func (buf *RespBuffer) loadError() (written bool) {
 	return buf.err != nil
 }
 
@@ -103,69 +137,106 @@ func (buf *RespBuffer) WaitChan() <-chan struct{} {
 	return buf.end
 }
 
-func (buf *RespBuffer) blockForBytes(amount int64) bool {
-	for {
-		buf.finLock <- struct{}{}
-		if int64(amount) <= buf.bytesAvailable {
-			buf.bytesAvailable -= amount
-			<-buf.finLock
-			return true
+// blockForBytes sleeps until amount of bytes is available or Finished is
+// called, available is number of bytes left if there isn't enough.
+// Returned bytes are copied since Write may reuse buffer memory
+func (buf *RespBuffer) blockForBytes(amount int64) (data []byte, available int64, ok bool) {
+	buf.lock.Lock()
+	defer buf.lock.Unlock()
+	for amount > buf.bytesAvailable && !buf.finished {
+		buf.dataCond.Wait()
+	}
+	if amount > buf.bytesAvailable {
+		return nil, buf.bytesAvailable, false
+	}
+	buf.bytesAvailable -= amount
+	data = make([]byte, amount)
+	copy(data, buf.buffer.Next(int(amount)))
+	if buf.snippetLen > 0 {
+		tail := data
+		if len(tail) > buf.snippetLen {
+			tail = tail[len(tail)-buf.snippetLen:]
 		}
-		select {
-		case <-buf.finished:
-			<-buf.finLock
-			return false
-		default:
+		buf.recent = append(buf.recent, tail...)
+		if len(buf.recent) > buf.snippetLen {
+			buf.recent = append(buf.recent[:0], buf.recent[len(buf.recent)-buf.snippetLen:]...)
 		}
-		<-buf.finLock
 	}
+	return data, amount, true
+}
+
+// snippet returns last parsed bytes followed by unparsed ones, up to
+// snippetLen of each, and offset of the first of them
+func (buf *RespBuffer) snippet() ([]byte, int64) {
+	buf.lock.Lock()
+	defer buf.lock.Unlock()
+	next := buf.buffer.Bytes()
+	if len(next) > buf.snippetLen {
+		next = next[:buf.snippetLen]
+	}
+	data := make([]byte, 0, len(buf.recent)+len(next))
+	data = append(data, buf.recent...)
+	data = append(data, next...)
+	return data, buf.offset - int64(len(buf.recent))
+}
+
+// Offset returns offset of next field from start of stream, it isn't reset
+// between frames
+func (buf *RespBuffer) Offset() int64 {
+	return buf.offset
 }
 
 // ParseHeader parses header
 func (buf *RespBuffer) ParseHeader(h *Header) {
-	buf.ParseInt32(&h.SvcID)
-	buf.ParseInt32(&h.BodyLength)
-	buf.ParseInt32(&h.RequestID)
-	buf.loadError("failed to parse header")
+	buf.parseInt32(&h.SvcID, "parse header")
+	buf.parseInt32(&h.BodyLength, "parse header")
+	buf.parseInt32(&h.RequestID, "parse header")
 	return
 }
 
 // ParseInt32 parses int32
 func (buf *RespBuffer) ParseInt32(i *int32) {
-	if buf.primalErrorCheck(int32Len, "failed to parse int32") {
-		*i = int32(binary.LittleEndian.Uint32(buf.buffer.Next(int32Len)))
+	buf.parseInt32(i, "parse int32")
+}
+
+func (buf *RespBuffer) parseInt32(i *int32, op string) {
+	if data, ok := buf.primalErrorCheck(int32Len, op, buf.offset); ok {
+		*i = int32(binary.LittleEndian.Uint32(data))
 	}
 }
 
 // ParseInt64 parses int64
 func (buf *RespBuffer) ParseInt64(i *int64) {
-	if buf.primalErrorCheck(int64Len, "failed to parse int64") {
-		*i = int64(binary.LittleEndian.Uint64(buf.buffer.Next(int64Len)))
+	if data, ok := buf.primalErrorCheck(int64Len, "parse int64", buf.offset); ok {
+		*i = int64(binary.LittleEndian.Uint64(data))
 	}
 }
 
 // ParseString parses string
 func (buf *RespBuffer) ParseString(s *string) {
-	var strLen int32
-	buf.parseStrLen(&strLen)
-	buf.parseStr(s, strLen)
-	buf.loadError("failed to parse string")
+	offset := buf.offset
+	strLen := buf.parseStrLen(offset)
+	if buf.loadError() {
+		return
+	}
+	buf.parseStr(s, strLen, offset)
 	return
 }
 
-func (buf *RespBuffer) parseStrLen(strLen *int32) {
-	buf.ParseInt32(strLen)
-	if buf.loadError("failed to parse str len") {
-		return
+func (buf *RespBuffer) parseStrLen(offset int64) int32 {
+	data, ok := buf.primalErrorCheck(int32Len, "parse string", offset)
+	if !ok {
+		return 0
 	}
-	if *strLen < 0 {
-		buf.createError(ErrIncorrectData, "failed to parse str len: value < 0")
+	strLen := int32(binary.LittleEndian.Uint32(data))
+	if strLen < 0 {
+		buf.createError(fmt.Errorf("negative length %d: %w", strLen, ErrIncorrectData), "parse string", offset)
 	}
-	return
+	return strLen
 }
 
-func (buf *RespBuffer) parseStr(s *string, strLen int32) {
-	if buf.primalErrorCheck(int64(strLen), "failed to parse str") {
-		*s = string(buf.buffer.Next(int(strLen)))
+func (buf *RespBuffer) parseStr(s *string, strLen int32, offset int64) {
+	if data, ok := buf.primalErrorCheck(int64(strLen), "parse string", offset); ok {
+		*s = string(data)
 	}
 }
@@ -2,12 +2,13 @@ package cubeapi_test
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/Apakhov/cube/cubeapi"
-	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
 )
 
@@ -82,7 +83,7 @@ func TestParseHeaderErr(t *testing.T) {
 		buf.Finished()
 		buf.Wait()
 		err := buf.Error()
-		if err == nil || errors.Cause(err) != cubeapi.ErrNotEnoughData {
+		if err == nil || !errors.Is(err, cubeapi.ErrNotEnoughData) {
 			errStr := ""
 			if err != nil {
 				errStr = err.Error()
@@ -151,7 +152,7 @@ func TestParseStringErr(t *testing.T) {
 		buf.Wait()
 
 		err := buf.Error()
-		if err == nil || errors.Cause(err) != testCase.err {
+		if err == nil || !errors.Is(err, testCase.err) {
 			errStr := ""
 			if err != nil {
 				errStr = err.Error()
@@ -196,7 +197,7 @@ func TestParseInt64Err(t *testing.T) {
 	buf.Wait()
 
 	err := buf.Error()
-	if err == nil || errors.Cause(err) != exp {
+	if err == nil || !errors.Is(err, exp) {
 		errStr := ""
 		if err != nil {
 			errStr = err.Error()
@@ -204,3 +205,60 @@ func TestParseInt64Err(t *testing.T) {
 		require.Equal(t, exp.Error(), errStr, "expected error")
 	}
 }
+
+func TestParseInt32Wait(t *testing.T) {
+	var res int32
+	buf := cubeapi.CreateRespBuffer([]byte{42})
+	buf.IncreaseParseLim(4)
+	go func() {
+		buf.ParseInt32(&res)
+		buf.End()
+	}()
+
+	for _, b := range []byte{0, 0, 0} {
+		time.Sleep(time.Millisecond * 10)
+		select {
+		case <-buf.WaitChan():
+			require.Fail(t, "parsing finished before all data is written")
+		default:
+		}
+		buf.Write([]byte{b})
+	}
+	buf.Wait()
+	require.NoError(t, buf.Error())
+	require.Equal(t, int32(42), res)
+}
+
+func TestParseInt32WaitFinished(t *testing.T) {
+	var res int32
+	buf := cubeapi.CreateRespBuffer([]byte{42})
+	buf.IncreaseParseLim(4)
+	go func() {
+		buf.ParseInt32(&res)
+		buf.End()
+	}()
+
+	time.Sleep(time.Millisecond * 10)
+	buf.Finished()
+	buf.Wait()
+	require.True(t, errors.Is(buf.Error(), cubeapi.ErrNotEnoughData))
+}
+
+func TestParseErrLengths(t *testing.T) {
+	buf := cubeapi.CreateRespBuffer(buildString("abcdef"))
+	buf.IncreaseParseLim(8)
+	buf.SetSnippetLen(2)
+	var s string
+	buf.ParseString(&s)
+
+	err := buf.Error()
+	require.True(t, errors.Is(err, cubeapi.ErrIncorrectLen))
+	var e *cubeapi.Error
+	require.True(t, errors.As(err, &e))
+	require.Equal(t, "parse string", e.Op)
+	require.Equal(t, int64(0), e.Offset)
+	require.Equal(t, int64(6), e.Expected)
+	require.Equal(t, int64(4), e.Available)
+	require.Equal(t, []byte{0, 0, 'a', 'b'}, e.Snippet)
+	require.Equal(t, int64(2), e.SnippetOffset)
+}
@@ -0,0 +1,320 @@
+package cubeapi
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// DialFunc dials connection to address
+type DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// PoolConfig configures Pool
+type PoolConfig struct {
+	// Network used for dialing, "tcp" if empty
+	Network string
+	// Dial dials new connections, net.Dialer is used if nil
+	Dial DialFunc
+	// MaxOpen limits number of open connections per address, unlimited if zero
+	MaxOpen int
+	// MaxIdle limits number of idle connections per address, DefaultMaxIdle if zero
+	MaxIdle int
+	// IdleTimeout closes connections staying idle for longer, unlimited if zero
+	IdleTimeout time.Duration
+}
+
+// DefaultMaxIdle is default number of idle connections per address
+const DefaultMaxIdle = 2
+
+// PoolStats represents pool statistics
+type PoolStats struct {
+	// Open is number of open connections
+	Open int
+	// Idle is number of idle connections
+	Idle int
+	// InUse is number of connections in use
+	InUse int
+	// Dials is total number of dialed connections
+	Dials int64
+	// Reuses is total number of idle connections given away
+	Reuses int64
+	// Evictions is total number of connections closed after error
+	Evictions int64
+	// IdleClosed is total number of connections closed as excess or stale idle
+	IdleClosed int64
+	// Waits is total number of Get calls waited for connection
+	Waits int64
+}
+
+// Pool keeps idle connections for reuse
+type Pool struct {
+	conf   PoolConfig
+	mu     sync.Mutex
+	addrs  map[string]*addrPool
+	stats  PoolStats
+	closed bool
+}
+
+type addrPool struct {
+	idle    []*PoolConn
+	open    int
+	waiters []chan *PoolConn
+	// draining makes released connections close, see Drain
+	draining bool
+}
+
+// PoolConn is connection taken from Pool. It must be released after usage
+type PoolConn struct {
+	net.Conn
+	pool      *Pool
+	addr      string
+	reused    bool
+	idleSince time.Time
+}
+
+// CreatePool creates Pool
+func CreatePool(conf PoolConfig) *Pool {
+	if conf.Network == "" {
+		conf.Network = "tcp"
+	}
+	if conf.Dial == nil {
+		conf.Dial = (&net.Dialer{}).DialContext
+	}
+	if conf.MaxIdle == 0 {
+		conf.MaxIdle = DefaultMaxIdle
+	}
+	return &Pool{
+		conf:  conf,
+		addrs: make(map[string]*addrPool),
+	}
+}
+
+// Get returns idle connection to address or dials new one. If address
+// reached MaxOpen connections Get waits for released one
+func (p *Pool) Get(ctx context.Context, address string) (*PoolConn, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, ErrPoolClosed
+	}
+	ap := p.addrPool(address)
+	ap.draining = false
+	if c := p.popIdle(ap); c != nil {
+		p.mu.Unlock()
+		return c, nil
+	}
+	if p.conf.MaxOpen <= 0 || ap.open < p.conf.MaxOpen {
+		ap.open++
+		p.mu.Unlock()
+		return p.dial(ctx, address)
+	}
+
+	wait := make(chan *PoolConn, 1)
+	ap.waiters = append(ap.waiters, wait)
+	p.stats.Waits++
+	p.mu.Unlock()
+
+	select {
+	case c, ok := <-wait:
+		return p.takeHanded(ctx, address, c, ok)
+	case <-ctx.Done():
+		p.mu.Lock()
+		removed := ap.removeWaiter(wait)
+		p.mu.Unlock()
+		if !removed {
+			// connection or slot was handed to us concurrently, pass it on
+			c, ok := <-wait
+			if ok && c != nil {
+				c.Release(nil)
+			} else if ok {
+				p.freeSlot(address)
+			}
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// takeHanded processes result of waiting: connection, free slot (nil) or
+// closed pool (!ok)
+func (p *Pool) takeHanded(ctx context.Context, address string, c *PoolConn, ok bool) (*PoolConn, error) {
+	if !ok {
+		return nil, ErrPoolClosed
+	}
+	if c != nil {
+		return c, nil
+	}
+	return p.dial(ctx, address)
+}
+
+func (p *Pool) dial(ctx context.Context, address string) (*PoolConn, error) {
+	conn, err := p.conf.Dial(ctx, p.conf.Network, address)
+	if err != nil {
+		p.freeSlot(address)
+		return nil, fmt.Errorf("failed to dial: %w", err)
+	}
+	p.mu.Lock()
+	p.stats.Dials++
+	p.mu.Unlock()
+	return &PoolConn{
+		Conn: conn,
+		pool: p,
+		addr: address,
+	}, nil
+}
+
+// freeSlot releases slot of connection that is not open anymore
+func (p *Pool) freeSlot(address string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ap := p.addrPool(address)
+	if len(ap.waiters) > 0 {
+		ap.popWaiter() <- nil
+		return
+	}
+	ap.open--
+}
+
+func (p *Pool) addrPool(address string) *addrPool {
+	ap, ok := p.addrs[address]
+	if !ok {
+		ap = &addrPool{}
+		p.addrs[address] = ap
+	}
+	return ap
+}
+
+// popIdle returns most recently used idle connection, closing stale ones
+func (p *Pool) popIdle(ap *addrPool) *PoolConn {
+	for len(ap.idle) > 0 {
+		c := ap.idle[len(ap.idle)-1]
+		ap.idle = ap.idle[:len(ap.idle)-1]
+		if p.conf.IdleTimeout > 0 && time.Since(c.idleSince) > p.conf.IdleTimeout {
+			ap.open--
+			p.stats.IdleClosed++
+			c.Conn.Close()
+			continue
+		}
+		c.reused = true
+		p.stats.Reuses++
+		return c
+	}
+	return nil
+}
+
+func (ap *addrPool) popWaiter() chan *PoolConn {
+	w := ap.waiters[0]
+	ap.waiters = ap.waiters[1:]
+	return w
+}
+
+func (ap *addrPool) removeWaiter(w chan *PoolConn) bool {
+	for i, wait := range ap.waiters {
+		if wait == w {
+			ap.waiters = append(ap.waiters[:i], ap.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Reused reports whether connection was taken from idle connections
+func (c *PoolConn) Reused() bool {
+	return c.reused
+}
+
+// Release returns connection to pool. Connection is closed if err is not nil
+// since it can be broken after read/write errors
+func (c *PoolConn) Release(err error) {
+	p := c.pool
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ap := p.addrPool(c.addr)
+
+	if err != nil || p.closed || ap.draining {
+		if err != nil {
+			p.stats.Evictions++
+		}
+		c.Conn.Close()
+		if len(ap.waiters) > 0 && !p.closed {
+			ap.popWaiter() <- nil
+			return
+		}
+		ap.open--
+		return
+	}
+
+	c.reused = true
+	if len(ap.waiters) > 0 {
+		p.stats.Reuses++
+		ap.popWaiter() <- c
+		return
+	}
+	if len(ap.idle) >= p.conf.MaxIdle {
+		p.stats.IdleClosed++
+		c.Conn.Close()
+		ap.open--
+		return
+	}
+	c.idleSince = time.Now()
+	ap.idle = append(ap.idle, c)
+}
+
+// Drain closes idle connections to address, e.g. one that is no longer
+// used. Connections in use are closed on release until Get is called with
+// address again
+func (p *Pool) Drain(address string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ap, ok := p.addrs[address]
+	if !ok {
+		return
+	}
+	for _, c := range ap.idle {
+		c.Conn.Close()
+		ap.open--
+		p.stats.IdleClosed++
+	}
+	ap.idle = nil
+	ap.draining = true
+	if ap.open == 0 && len(ap.waiters) == 0 {
+		delete(p.addrs, address)
+	}
+}
+
+// Stats returns pool statistics
+func (p *Pool) Stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.stats
+	for _, ap := range p.addrs {
+		s.Open += ap.open
+		s.Idle += len(ap.idle)
+	}
+	s.InUse = s.Open - s.Idle
+	return s
+}
+
+// Close closes idle connections and makes pool unusable. Connections in use
+// are closed on release
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	for _, ap := range p.addrs {
+		for _, c := range ap.idle {
+			c.Conn.Close()
+			ap.open--
+		}
+		ap.idle = nil
+		for _, w := range ap.waiters {
+			close(w)
+		}
+		ap.waiters = nil
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package cubeapi_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Apakhov/cube/cubeapi"
+	"github.com/stretchr/testify/require"
+)
+
+// pipeDial dials in-memory connections
+func pipeDial(ctx context.Context, network, address string) (net.Conn, error) {
+	c, s := net.Pipe()
+	go func() {
+		buf := make([]byte, 1)
+		s.Read(buf)
+		s.Close()
+	}()
+	return c, nil
+}
+
+func TestPoolReuse(t *testing.T) {
+	pool := cubeapi.CreatePool(cubeapi.PoolConfig{Dial: pipeDial})
+	defer pool.Close()
+
+	c1, err := pool.Get(context.Background(), "a")
+	require.NoError(t, err)
+	require.False(t, c1.Reused())
+	c1.Release(nil)
+
+	c2, err := pool.Get(context.Background(), "a")
+	require.NoError(t, err)
+	require.True(t, c2.Reused())
+	require.Equal(t, c1, c2)
+
+	c3, err := pool.Get(context.Background(), "b")
+	require.NoError(t, err)
+	require.False(t, c3.Reused())
+
+	require.Equal(t, cubeapi.PoolStats{Open: 2, InUse: 2, Dials: 2, Reuses: 1}, pool.Stats())
+	c2.Release(nil)
+	c3.Release(nil)
+	require.Equal(t, cubeapi.PoolStats{Open: 2, Idle: 2, Dials: 2, Reuses: 1}, pool.Stats())
+}
+
+func TestPoolEvict(t *testing.T) {
+	pool := cubeapi.CreatePool(cubeapi.PoolConfig{Dial: pipeDial})
+	defer pool.Close()
+
+	c1, err := pool.Get(context.Background(), "a")
+	require.NoError(t, err)
+	c1.Release(net.ErrWriteToConnected)
+
+	c2, err := pool.Get(context.Background(), "a")
+	require.NoError(t, err)
+	require.False(t, c2.Reused())
+	c2.Release(nil)
+
+	require.Equal(t, cubeapi.PoolStats{Open: 1, Idle: 1, Dials: 2, Evictions: 1}, pool.Stats())
+}
+
+func TestPoolMaxIdle(t *testing.T) {
+	pool := cubeapi.CreatePool(cubeapi.PoolConfig{Dial: pipeDial, MaxIdle: 1})
+	defer pool.Close()
+
+	c1, err := pool.Get(context.Background(), "a")
+	require.NoError(t, err)
+	c2, err := pool.Get(context.Background(), "a")
+	require.NoError(t, err)
+	c1.Release(nil)
+	c2.Release(nil)
+
+	require.Equal(t, cubeapi.PoolStats{Open: 1, Idle: 1, Dials: 2, IdleClosed: 1}, pool.Stats())
+}
+
+func TestPoolIdleTimeout(t *testing.T) {
+	pool := cubeapi.CreatePool(cubeapi.PoolConfig{Dial: pipeDial, IdleTimeout: time.Millisecond * 10})
+	defer pool.Close()
+
+	c1, err := pool.Get(context.Background(), "a")
+	require.NoError(t, err)
+	c1.Release(nil)
+	time.Sleep(time.Millisecond * 20)
+
+	c2, err := pool.Get(context.Background(), "a")
+	require.NoError(t, err)
+	require.False(t, c2.Reused())
+	require.Equal(t, cubeapi.PoolStats{Open: 1, InUse: 1, Dials: 2, IdleClosed: 1}, pool.Stats())
+}
+
+func TestPoolMaxOpen(t *testing.T) {
+	pool := cubeapi.CreatePool(cubeapi.PoolConfig{Dial: pipeDial, MaxOpen: 1})
+	defer pool.Close()
+
+	c1, err := pool.Get(context.Background(), "a")
+	require.NoError(t, err)
+
+	got := make(chan *cubeapi.PoolConn)
+	go func() {
+		c, err := pool.Get(context.Background(), "a")
+		require.NoError(t, err)
+		got <- c
+	}()
+
+	select {
+	case <-got:
+		require.Fail(t, "MaxOpen is exceeded")
+	case <-time.After(time.Millisecond * 50):
+	}
+	c1.Release(nil)
+	c2 := <-got
+	require.Equal(t, c1, c2)
+	require.Equal(t, int64(1), pool.Stats().Waits)
+}
+
+func TestPoolMaxOpenEvict(t *testing.T) {
+	pool := cubeapi.CreatePool(cubeapi.PoolConfig{Dial: pipeDial, MaxOpen: 1})
+	defer pool.Close()
+
+	c1, err := pool.Get(context.Background(), "a")
+	require.NoError(t, err)
+
+	got := make(chan *cubeapi.PoolConn)
+	go func() {
+		c, err := pool.Get(context.Background(), "a")
+		require.NoError(t, err)
+		got <- c
+	}()
+	time.Sleep(time.Millisecond * 50)
+	c1.Release(net.ErrWriteToConnected)
+	c2 := <-got
+	require.False(t, c2.Reused())
+	require.Equal(t, cubeapi.PoolStats{Open: 1, InUse: 1, Dials: 2, Evictions: 1, Waits: 1}, pool.Stats())
+}
+
+func TestPoolWaitCancel(t *testing.T) {
+	pool := cubeapi.CreatePool(cubeapi.PoolConfig{Dial: pipeDial, MaxOpen: 1})
+	defer pool.Close()
+
+	c1, err := pool.Get(context.Background(), "a")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	_, err = pool.Get(ctx, "a")
+	require.Equal(t, context.DeadlineExceeded, err)
+
+	c1.Release(nil)
+	require.Equal(t, cubeapi.PoolStats{Open: 1, Idle: 1, Dials: 1, Waits: 1}, pool.Stats())
+}
+
+func TestPoolClose(t *testing.T) {
+	pool := cubeapi.CreatePool(cubeapi.PoolConfig{Dial: pipeDial})
+
+	c1, err := pool.Get(context.Background(), "a")
+	require.NoError(t, err)
+	c2, err := pool.Get(context.Background(), "a")
+	require.NoError(t, err)
+	c1.Release(nil)
+
+	require.NoError(t, pool.Close())
+	_, err = pool.Get(context.Background(), "a")
+	require.Equal(t, cubeapi.ErrPoolClosed, err)
+
+	c2.Release(nil)
+	require.Equal(t, 0, pool.Stats().Open)
+}
+
+func TestPoolDrain(t *testing.T) {
+	pool := cubeapi.CreatePool(cubeapi.PoolConfig{Dial: pipeDial})
+	defer pool.Close()
+
+	c1, err := pool.Get(context.Background(), "a")
+	require.NoError(t, err)
+	c2, err := pool.Get(context.Background(), "a")
+	require.NoError(t, err)
+	c3, err := pool.Get(context.Background(), "b")
+	require.NoError(t, err)
+	c1.Release(nil)
+	c3.Release(nil)
+
+	pool.Drain("a")
+	require.Equal(t, cubeapi.PoolStats{Open: 2, Idle: 1, InUse: 1, Dials: 3, IdleClosed: 1}, pool.Stats())
+	// connection in use is closed on release
+	c2.Release(nil)
+	require.Equal(t, cubeapi.PoolStats{Open: 1, Idle: 1, Dials: 3, IdleClosed: 1}, pool.Stats())
+
+	// address is pooled again after Get
+	c1, err = pool.Get(context.Background(), "a")
+	require.NoError(t, err)
+	c1.Release(nil)
+	require.Equal(t, cubeapi.PoolStats{Open: 2, Idle: 2, Dials: 4, IdleClosed: 1}, pool.Stats())
+}
@@ -3,8 +3,6 @@ package cubeapi
 import (
 	"encoding/binary"
 	"math"
-
-	"github.com/pkg/errors"
 )
 
 // SendBuffer struct for encoding request
@@ -29,10 +27,15 @@ func (buf *SendBuffer) WriteHeader(svcID int32, bodyLen int32) {
 	buf.WriteInt32OnPos(0x00000000, 8)
 }
 
+// SetRequestID sets RequestID of request header
+func (buf *SendBuffer) SetRequestID(requestID int32) {
+	buf.WriteInt32OnPos(requestID, 8)
+}
+
 // WriteInt32OnPos writes int32 to request on position
 func (buf *SendBuffer) WriteInt32OnPos(i int32, pos int) error {
 	if pos < 0 || buf.Len() < pos+4 {
-		return errors.Wrap(ErrBadWritingPos, "can't write int32")
+		return wrapError("write int32", int64(pos), ErrBadWritingPos)
 	}
 	binary.LittleEndian.PutUint32(buf.buffer[pos:pos+4], uint32(i))
 	return nil
@@ -45,10 +48,17 @@ func (buf *SendBuffer) WriteInt32(i int32) {
 	binary.LittleEndian.PutUint32(buf.buffer[l:], uint32(i))
 }
 
+// WriteInt64 writes int64 to request
+func (buf *SendBuffer) WriteInt64(i int64) {
+	l := buf.Len()
+	buf.buffer = append(buf.buffer, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint64(buf.buffer[l:], uint64(i))
+}
+
 // WriteString writes string to request
 func (buf *SendBuffer) WriteString(s string) error {
 	if len(s) > math.MaxInt32 {
-		return errors.Wrap(ErrStringTooLong, "can't write string")
+		return wrapError("write string", int64(buf.Len()), ErrStringTooLong)
 	}
 	buf.WriteStrLen(int32(len(s)))
 	buf.WriteStr(s)
@@ -2,11 +2,11 @@ package cubeapi_test
 
 import (
 	"bytes"
+	"errors"
 	"math"
 	"testing"
 
 	"github.com/Apakhov/cube/cubeapi"
-	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
 )
 
@@ -52,7 +52,7 @@ func TestWriteStringErr(t *testing.T) {
 	buf := cubeapi.CreateSendBuffer()
 	buf.WriteHeader(0x1, 0x2)
 	err := buf.WriteString(string(make([]byte, int64(math.MaxInt32)+1, int64(math.MaxInt32)+1)))
-	if err == nil || errors.Cause(err) != cubeapi.ErrStringTooLong {
+	if err == nil || !errors.Is(err, cubeapi.ErrStringTooLong) {
 		errStr := ""
 		if err != nil {
 			errStr = err.Error()
@@ -66,7 +66,7 @@ func TestWriteInt32OnPosErr(t *testing.T) {
 	buf := cubeapi.CreateSendBuffer()
 	buf.WriteHeader(0x1, 0x2)
 	err := buf.WriteInt32OnPos(42, buf.Len()+100)
-	if err == nil || errors.Cause(err) != cubeapi.ErrBadWritingPos {
+	if err == nil || !errors.Is(err, cubeapi.ErrBadWritingPos) {
 		errStr := ""
 		if err != nil {
 			errStr = err.Error()
@@ -75,3 +75,22 @@ func TestWriteInt32OnPosErr(t *testing.T) {
 		return
 	}
 }
+
+func TestSetRequestID(t *testing.T) {
+	buf := cubeapi.CreateSendBuffer()
+	buf.WriteHeader(0x1, 0x2)
+	buf.SetRequestID(0x3)
+	if !bytes.Equal(buf.Bytes(), []byte{1, 0, 0, 0, 2, 0, 0, 0, 3, 0, 0, 0}) {
+		require.Equal(t, buf.Bytes(), []byte{1, 0, 0, 0, 2, 0, 0, 0, 3, 0, 0, 0})
+	}
+}
+
+func TestWriteInt64(t *testing.T) {
+	buf := cubeapi.CreateSendBuffer()
+	buf.WriteInt64(0x0102030405060708)
+	buf.WriteHeader(0x1, 0x8)
+	exp := []byte{1, 0, 0, 0, 8, 0, 0, 0, 0, 0, 0, 0, 8, 7, 6, 5, 4, 3, 2, 1}
+	if !bytes.Equal(buf.Bytes(), exp) {
+		require.Equal(t, buf.Bytes(), exp)
+	}
+}
@@ -0,0 +1,267 @@
+package cubeapi
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrCodeUnknownSvc is return code of reply to request of unregistered
+// service, the same as OAUTH2 CUBE_OAUTH2_ERR_UNKNOWN_MSG
+const ErrCodeUnknownSvc = int32(0x00000003)
+
+// ErrStringUnknownSvc is error string of reply to request of unregistered
+// service
+const ErrStringUnknownSvc = "unknown svc id"
+
+// Request represents request received by Server
+type Request struct {
+	Header     Header
+	Body       []byte
+	RemoteAddr net.Addr
+}
+
+// Handler answers requests of service. Returned fields form response body,
+// response header has svc id and request id of request. Returned error
+// closes connection without answer
+type Handler interface {
+	ServeCube(ctx context.Context, req *Request) ([]Field, error)
+}
+
+// HandlerFunc adapts function to Handler
+type HandlerFunc func(ctx context.Context, req *Request) ([]Field, error)
+
+// ServeCube implements Handler interface
+func (f HandlerFunc) ServeCube(ctx context.Context, req *Request) ([]Field, error) {
+	return f(ctx, req)
+}
+
+// unknownSvcHandler answers ErrCodeUnknownSvc
+var unknownSvcHandler = HandlerFunc(func(ctx context.Context, req *Request) ([]Field, error) {
+	return []Field{Int32(ErrCodeUnknownSvc), String(ErrStringUnknownSvc)}, nil
+})
+
+// Server reads requests from connections and dispatches them to handlers
+// registered by svc id. Requests of one connection are handled concurrently
+// and may be answered out of order, so clients should match responses by
+// RequestID
+type Server struct {
+	lock      sync.Mutex
+	handlers  map[int32]Handler
+	unknown   Handler
+	listeners map[net.Listener]struct{}
+	conns     map[*serverConn]struct{}
+	connWG    sync.WaitGroup
+	shutdown  bool
+	ctx       context.Context
+	cancel    context.CancelFunc
+}
+
+type serverConn struct {
+	conn       net.Conn
+	wlock      sync.Mutex
+	handlersWG sync.WaitGroup
+}
+
+// CreateServer creates Server
+func CreateServer() *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Server{
+		handlers:  make(map[int32]Handler),
+		unknown:   unknownSvcHandler,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[*serverConn]struct{}),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+}
+
+// Handle registers handler of service with svcID
+func (s *Server) Handle(svcID int32, h Handler) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.handlers[svcID] = h
+}
+
+// HandleUnknown registers handler of requests of unregistered services
+// instead of default one replying ErrCodeUnknownSvc
+func (s *Server) HandleUnknown(h Handler) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.unknown = h
+}
+
+func (s *Server) handler(svcID int32) Handler {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if h, ok := s.handlers[svcID]; ok {
+		return h
+	}
+	return s.unknown
+}
+
+// ListenAndServe listens on address and serves connections, see Serve
+func (s *Server) ListenAndServe(network, address string) error {
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+	return s.Serve(l)
+}
+
+// ListenAndServeTLS listens on address and serves TLS connections
+// configured by conf, see Serve
+func (s *Server) ListenAndServeTLS(network, address string, conf *tls.Config) error {
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+	return s.Serve(tls.NewListener(l, conf))
+}
+
+// Serve accepts connections from l and serves them until Shutdown or Close.
+// It always returns non-nil error, ErrServerClosed after Shutdown or Close
+func (s *Server) Serve(l net.Listener) error {
+	s.lock.Lock()
+	if s.shutdown {
+		s.lock.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.lock.Unlock()
+
+	defer func() {
+		s.lock.Lock()
+		delete(s.listeners, l)
+		s.lock.Unlock()
+		l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.lock.Lock()
+			shutdown := s.shutdown
+			s.lock.Unlock()
+			if shutdown {
+				return ErrServerClosed
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(time.Millisecond * 5)
+				continue
+			}
+			return fmt.Errorf("failed to accept: %w", err)
+		}
+
+		sc := &serverConn{conn: conn}
+		s.lock.Lock()
+		if s.shutdown {
+			s.lock.Unlock()
+			conn.Close()
+			return ErrServerClosed
+		}
+		s.conns[sc] = struct{}{}
+		s.connWG.Add(1)
+		s.lock.Unlock()
+		go s.serveConn(sc)
+	}
+}
+
+// serveConn reads requests until connection fails or Shutdown interrupts
+// reading, then waits for handlers and closes connection
+func (s *Server) serveConn(sc *serverConn) {
+	defer s.connWG.Done()
+	defer func() {
+		sc.handlersWG.Wait()
+		sc.conn.Close()
+		s.lock.Lock()
+		delete(s.conns, sc)
+		s.lock.Unlock()
+	}()
+
+	d := CreateDecoder(bufio.NewReader(sc.conn))
+	for {
+		req := &Request{RemoteAddr: sc.conn.RemoteAddr()}
+		body, err := d.ReadFrame(&req.Header)
+		if err != nil {
+			return
+		}
+		req.Body = body
+
+		sc.handlersWG.Add(1)
+		go s.handle(sc, req)
+	}
+}
+
+func (s *Server) handle(sc *serverConn, req *Request) {
+	defer sc.handlersWG.Done()
+	fields, err := s.handler(req.Header.SvcID).ServeCube(s.ctx, req)
+	if err != nil {
+		sc.conn.Close()
+		return
+	}
+
+	resp := &bytes.Buffer{}
+	err = CreateEncoder(resp).WriteFrame(req.Header.SvcID, req.Header.RequestID, fields...)
+	if err != nil {
+		sc.conn.Close()
+		return
+	}
+	sc.wlock.Lock()
+	defer sc.wlock.Unlock()
+	if _, err = sc.conn.Write(resp.Bytes()); err != nil {
+		sc.conn.Close()
+	}
+}
+
+// Shutdown stops accepting connections and reading requests, then waits
+// for in-flight requests to be answered and closes connections. If ctx is
+// done before, connections are closed forcibly and ctx error is returned
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.lock.Lock()
+	s.shutdown = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for sc := range s.conns {
+		// interrupting reading, in-flight requests are still answered
+		sc.conn.SetReadDeadline(time.Unix(1, 0))
+	}
+	s.lock.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		s.connWG.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		s.cancel()
+		return nil
+	case <-ctx.Done():
+		s.Close()
+		return ctx.Err()
+	}
+}
+
+// Close closes listeners and connections immediately, contexts of in-flight
+// requests are cancelled
+func (s *Server) Close() error {
+	s.lock.Lock()
+	s.shutdown = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for sc := range s.conns {
+		sc.conn.Close()
+	}
+	s.lock.Unlock()
+	s.cancel()
+	return nil
+}
@@ -0,0 +1,140 @@
+package cubeapi_test
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Apakhov/cube/cubeapi"
+	"github.com/stretchr/testify/require"
+)
+
+// echoHandler answers int32 of request body, waiting value milliseconds
+var echoHandler = cubeapi.HandlerFunc(func(ctx context.Context, req *cubeapi.Request) ([]cubeapi.Field, error) {
+	value := int32(binary.LittleEndian.Uint32(req.Body))
+	time.Sleep(time.Duration(value) * time.Millisecond)
+	return []cubeapi.Field{cubeapi.Int32(value)}, nil
+})
+
+// startServer starts server with echoHandler registered with svc id 1
+func startServer(t *testing.T) (*cubeapi.Server, string, chan error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := cubeapi.CreateServer()
+	s.Handle(1, echoHandler)
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Serve(l)
+	}()
+	return s, l.Addr().String(), served
+}
+
+func TestServerDispatch(t *testing.T) {
+	s, addr, _ := startServer(t)
+	defer s.Close()
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write(buildFrame(7, 1))
+	require.NoError(t, err)
+	frame, err := cubeapi.ReadFrame(conn)
+	require.NoError(t, err)
+	require.Equal(t, buildFrame(7, 1), frame)
+}
+
+func TestServerUnknownSvc(t *testing.T) {
+	s, addr, _ := startServer(t)
+	defer s.Close()
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	req := buildFrame(3, 0)
+	binary.LittleEndian.PutUint32(req[0:4], 5)
+	_, err = conn.Write(req)
+	require.NoError(t, err)
+
+	h := cubeapi.Header{}
+	d := cubeapi.CreateDecoder(conn)
+	require.NoError(t, d.ReadHeader(&h))
+	require.Equal(t, int32(5), h.SvcID)
+	require.Equal(t, int32(3), h.RequestID)
+	var code int32
+	var str string
+	require.NoError(t, d.ReadInt32(&code))
+	require.NoError(t, d.ReadString(&str))
+	require.Equal(t, cubeapi.ErrCodeUnknownSvc, code)
+	require.Equal(t, cubeapi.ErrStringUnknownSvc, str)
+}
+
+func TestServerPipelined(t *testing.T) {
+	s, addr, _ := startServer(t)
+	defer s.Close()
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// slower request is sent first and answered last
+	_, err = conn.Write(append(buildFrame(1, 100), buildFrame(2, 0)...))
+	require.NoError(t, err)
+	first, err := cubeapi.ReadFrame(conn)
+	require.NoError(t, err)
+	second, err := cubeapi.ReadFrame(conn)
+	require.NoError(t, err)
+	require.Equal(t, buildFrame(2, 0), first)
+	require.Equal(t, buildFrame(1, 100), second)
+}
+
+func TestServerShutdownDrains(t *testing.T) {
+	s, addr, served := startServer(t)
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write(buildFrame(1, 200))
+	require.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- s.Shutdown(context.Background())
+	}()
+	frame, err := cubeapi.ReadFrame(conn)
+	require.NoError(t, err)
+	require.Equal(t, buildFrame(1, 200), frame)
+	require.NoError(t, <-shutdown)
+	require.Equal(t, cubeapi.ErrServerClosed, <-served)
+
+	_, err = cubeapi.ReadFrame(conn)
+	require.Error(t, err)
+	_, err = net.Dial("tcp", addr)
+	require.Error(t, err)
+}
+
+func TestServerShutdownTimeout(t *testing.T) {
+	s, addr, _ := startServer(t)
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write(buildFrame(1, 500))
+	require.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded, s.Shutdown(ctx))
+	_, err = cubeapi.ReadFrame(conn)
+	require.Error(t, err)
+}
+
+func TestServerServeAfterClose(t *testing.T) {
+	s := cubeapi.CreateServer()
+	require.NoError(t, s.Close())
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	require.Equal(t, cubeapi.ErrServerClosed, s.Serve(l))
+}
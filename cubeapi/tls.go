@@ -0,0 +1,116 @@
+package cubeapi
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// TLSOptions are PEM files and settings of TLS connections
+type TLSOptions struct {
+	// CAFile is bundle of certificates of authorities. Client verifies
+	// server with it instead of system roots, server requires and verifies
+	// client certificates with it
+	CAFile string
+	// CertFile and KeyFile are certificate and its key, required by server.
+	// Client presents them to server requiring client certificates
+	CertFile string
+	KeyFile  string
+	// ServerName overrides name client verifies certificate of server with
+	// and sends as SNI
+	ServerName string
+	// MinVersion is the lowest accepted version, tls.VersionTLS12 if zero
+	MinVersion uint16
+}
+
+// CreateClientTLSConfig creates config of client connections
+func CreateClientTLSConfig(opts TLSOptions) (*tls.Config, error) {
+	conf := &tls.Config{
+		ServerName: opts.ServerName,
+		MinVersion: opts.MinVersion,
+	}
+	if conf.MinVersion == 0 {
+		conf.MinVersion = tls.VersionTLS12
+	}
+	if opts.CAFile != "" {
+		pool, err := loadCertPool(opts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.RootCAs = pool
+	}
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		cert, err := loadKeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
+
+// CreateServerTLSConfig creates config of server connections. Clients are
+// required to present certificates signed by CAFile if it is set
+func CreateServerTLSConfig(opts TLSOptions) (*tls.Config, error) {
+	cert, err := loadKeyPair(opts.CertFile, opts.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	conf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   opts.MinVersion,
+	}
+	if conf.MinVersion == 0 {
+		conf.MinVersion = tls.VersionTLS12
+	}
+	if opts.CAFile != "" {
+		pool, err := loadCertPool(opts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.ClientCAs = pool
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return conf, nil
+}
+
+// tlsVersions are versions by names accepted by ParseTLSVersion
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseTLSVersion returns TLS version by name, e.g. "1.2"
+func ParseTLSVersion(name string) (uint16, error) {
+	if v, ok := tlsVersions[name]; ok {
+		return v, nil
+	}
+	return 0, fmt.Errorf("unknown TLS version %q", name)
+}
+
+func loadKeyPair(certFile, keyFile string) (tls.Certificate, error) {
+	if certFile == "" || keyFile == "" {
+		return tls.Certificate{}, errors.New("expected both certificate and key files")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to load key pair: %w", err)
+	}
+	return cert, nil
+}
+
+func loadCertPool(file string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates in CA file %s", file)
+	}
+	return pool, nil
+}
@@ -0,0 +1,119 @@
+package cubeapi_test
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Apakhov/cube/cubeapi"
+	"github.com/Apakhov/cube/cubeapi/oauth2/oauth2test"
+	"github.com/stretchr/testify/require"
+)
+
+// writeCertificates writes PEM files of certs to dir
+func writeCertificates(t *testing.T, dir string, certs *oauth2test.Certificates) {
+	for name, data := range map[string][]byte{
+		"ca.pem":         certs.CACert,
+		"server.pem":     certs.ServerCert,
+		"server-key.pem": certs.ServerKey,
+		"client.pem":     certs.ClientCert,
+		"client-key.pem": certs.ClientKey,
+	} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), data, 0600))
+	}
+}
+
+func TestServeTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cubeapi")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeCertificates(t, dir, oauth2test.CreateCertificates())
+
+	serverConf, err := cubeapi.CreateServerTLSConfig(cubeapi.TLSOptions{
+		CAFile:   filepath.Join(dir, "ca.pem"),
+		CertFile: filepath.Join(dir, "server.pem"),
+		KeyFile:  filepath.Join(dir, "server-key.pem"),
+	})
+	require.NoError(t, err)
+	require.Equal(t, tls.RequireAndVerifyClientCert, serverConf.ClientAuth)
+	require.Equal(t, uint16(tls.VersionTLS12), serverConf.MinVersion)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+	s := cubeapi.CreateServer()
+	s.Handle(1, echoHandler)
+	served := make(chan error, 1)
+	go func() {
+		served <- s.ListenAndServeTLS("tcp", addr, serverConf)
+	}()
+	defer s.Close()
+
+	clientConf, err := cubeapi.CreateClientTLSConfig(cubeapi.TLSOptions{
+		CAFile:     filepath.Join(dir, "ca.pem"),
+		CertFile:   filepath.Join(dir, "client.pem"),
+		KeyFile:    filepath.Join(dir, "client-key.pem"),
+		MinVersion: tls.VersionTLS13,
+	})
+	require.NoError(t, err)
+	require.Equal(t, "", clientConf.ServerName)
+	require.Equal(t, uint16(tls.VersionTLS13), clientConf.MinVersion)
+
+	var conn *tls.Conn
+	for i := 0; i < 100 && conn == nil; i++ {
+		// waiting for server to listen
+		if conn, err = tls.Dial("tcp", addr, clientConf); err != nil {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write(buildFrame(7, 1))
+	require.NoError(t, err)
+	frame, err := cubeapi.ReadFrame(conn)
+	require.NoError(t, err)
+	require.Equal(t, buildFrame(7, 1), frame)
+
+	s.Close()
+	require.Equal(t, cubeapi.ErrServerClosed, <-served)
+}
+
+func TestTLSConfigErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cubeapi")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeCertificates(t, dir, oauth2test.CreateCertificates())
+
+	_, err = cubeapi.CreateServerTLSConfig(cubeapi.TLSOptions{CertFile: filepath.Join(dir, "server.pem")})
+	require.Error(t, err)
+	_, err = cubeapi.CreateClientTLSConfig(cubeapi.TLSOptions{KeyFile: filepath.Join(dir, "client-key.pem")})
+	require.Error(t, err)
+	_, err = cubeapi.CreateClientTLSConfig(cubeapi.TLSOptions{CAFile: filepath.Join(dir, "missing.pem")})
+	require.Error(t, err)
+	// key doesn't match certificate
+	_, err = cubeapi.CreateClientTLSConfig(cubeapi.TLSOptions{
+		CertFile: filepath.Join(dir, "client.pem"),
+		KeyFile:  filepath.Join(dir, "server-key.pem"),
+	})
+	require.Error(t, err)
+	_, err = cubeapi.CreateClientTLSConfig(cubeapi.TLSOptions{CAFile: filepath.Join(dir, "server-key.pem")})
+	require.Error(t, err)
+
+	conf, err := cubeapi.CreateClientTLSConfig(cubeapi.TLSOptions{ServerName: "cube.test"})
+	require.NoError(t, err)
+	require.Equal(t, "cube.test", conf.ServerName)
+	require.Nil(t, conf.RootCAs)
+}
+
+func TestParseTLSVersion(t *testing.T) {
+	v, err := cubeapi.ParseTLSVersion("1.3")
+	require.NoError(t, err)
+	require.Equal(t, uint16(tls.VersionTLS13), v)
+	_, err = cubeapi.ParseTLSVersion("3.0")
+	require.Error(t, err)
+}
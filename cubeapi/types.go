@@ -13,16 +13,6 @@ const int8Len = 1
 const int32Len = 4
 const int64Len = 8
 
-// Error errors
-type Error struct {
-	msg string
-}
-
-// Error imlements error interface
-func (e *Error) Error() string {
-	return e.msg
-}
-
 var (
 	// ErrNotEnoughData not enough data to parse
 	ErrNotEnoughData = &Error{
@@ -52,4 +42,20 @@ var (
 	ErrIncorrectSVCID = &Error{
 		msg: "Incorrect svc id",
 	}
+	// ErrPoolClosed pool is closed
+	ErrPoolClosed = &Error{
+		msg: "Pool is closed",
+	}
+	// ErrUnknownRequestID response has RequestID no request is waiting for
+	ErrUnknownRequestID = &Error{
+		msg: "Unknown request id",
+	}
+	// ErrMuxClosed multiplexed connection is closed
+	ErrMuxClosed = &Error{
+		msg: "Multiplexed connection is closed",
+	}
+	// ErrServerClosed server is shut down or closed
+	ErrServerClosed = &Error{
+		msg: "Server is closed",
+	}
 )
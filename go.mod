@@ -1,8 +1,8 @@
 module github.com/Apakhov/cube
 
-go 1.12
+go 1.13
 
 require (
-	github.com/pkg/errors v0.8.1
 	github.com/stretchr/testify v1.3.0
+	gopkg.in/yaml.v2 v2.4.0
 )
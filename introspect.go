@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/Apakhov/cube/cubeapi/oauth2/oauth2http"
+)
+
+// introspectServerCmd runs RFC 7662 introspection endpoint validating
+// tokens with cube
+func introspectServerCmd(args []string) {
+	fs := flag.NewFlagSet("cube introspect-server", flag.ContinueOnError)
+	addr := fs.String("addr", "localhost:8080", "address to listen on")
+	cube := fs.String("cube", "localhost:3333", "address of cube server, host:port, or comma-separated addresses of replicas")
+	timeout := fs.Duration("timeout", 5*time.Second, "time before cube request deadline")
+	tlsOpts := addClientTLSFlags(fs)
+	fs.Usage = func() {
+		fmt.Println(`Usage of cube introspect-server:
+	cube introspect-server [-addr host:port] [-cube host:port]
+serves POST /introspect with form fields token and scope
+flags:`)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		os.Exit(-1)
+	}
+
+	tlsConf, err := tlsOpts.clientConfig()
+	if err != nil {
+		fmt.Println("failed to configure TLS", err.Error())
+		os.Exit(-1)
+	}
+	client := createGatewayClient(*cube, tlsConf)
+	defer client.Close()
+
+	mux := http.NewServeMux()
+	// timed out validation is answered as unavailable cube
+	mux.Handle("/introspect", oauth2http.IntrospectionHandler(withTimeout(client, *timeout)))
+	server := &http.Server{Addr: *addr, Handler: mux}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-stop
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("failed to shutdown gracefully: %v", err)
+		}
+	}()
+
+	log.Printf("serving introspection on %s, validating at %s", *addr, *cube)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		fmt.Println("failed to serve", err.Error())
+		os.Exit(-1)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Apakhov/cube/cubeapi/oauth2"
+	"github.com/Apakhov/cube/cubeapi/oauth2/oauth2http"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntrospectTimeout(t *testing.T) {
+	slow := oauth2.ValidatorFunc(func(ctx context.Context, token, scope string) (*oauth2.ResponseOAUTH2, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	h := oauth2http.IntrospectionHandler(withTimeout(slow, 20*time.Millisecond))
+
+	form := url.Values{"token": {"abracadabra"}, "scope": {"test"}}
+	r := httptest.NewRequest("POST", "/introspect", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	body := map[string]string{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	require.Equal(t, "temporarily_unavailable", body["error"])
+}
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
-	"io"
+	"log"
 	"net"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/Apakhov/cube/cubeapi/oauth2"
@@ -18,6 +22,11 @@ var port = fs.Int("port", 0, "tcp/ip server port, positive integer")
 var token = fs.String("token", "", "your token, non-empty string")
 var scope = fs.String("scope", "", "scope of the, token non-empty string")
 var secondsToOperate = fs.Int64("sec", 10, "time before request deadline")
+var attempts = fs.Int("attempts", 1, "max attempts of validation on transient failures")
+var endpoints = fs.String("endpoints", "", "comma-separated addresses of cube replicas, host:port, used instead of host and port")
+var balancing = fs.String("balancing", "round-robin", "balancing of endpoints: round-robin, least-outstanding or two-choices")
+var resolve = fs.Bool("resolve", false, "resolve host names of endpoints to all their addresses")
+var tlsOpts = addClientTLSFlags(fs)
 
 func init() {
 	fs.StringVar(host, "h", "", "tcp/ip server host, non-empty string")
@@ -28,6 +37,10 @@ func init() {
 	fs.Usage = func() {
 		fmt.Println(`Usage of cube:
 	cube host port token scope
+	cube -endpoints host:port,host:port token scope
+	cube serve -tokens file (run "cube serve -help" for details)
+	cube introspect-server -cube host:port (run "cube introspect-server -help" for details)
+	cube proxy -config file -cube host:port (run "cube proxy -help" for details)
 or with flags:`)
 
 		fs.PrintDefaults()
@@ -58,94 +71,107 @@ func checkIntFlag(flag *int, flagName string, pos int) int {
 	return pos
 }
 
-const buffLen = 16
+// commands are subcommands of cube, token is validated without subcommand
+var commands = map[string]func(args []string){
+	"serve":             serveCmd,
+	"introspect-server": introspectServerCmd,
+	"proxy":             proxyCmd,
+}
 
-func main() {
-	if err := fs.Parse(os.Args[1:]); err != nil {
-		os.Exit(-1)
+// splitEndpoints splits comma-separated list of addresses
+func splitEndpoints(list string) []string {
+	var addresses []string
+	for _, addr := range strings.Split(list, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addresses = append(addresses, addr)
+		}
 	}
+	return addresses
+}
 
-	curParam := 0
-
-	curParam = checkStringFlag(host, "host", curParam)
-	curParam = checkIntFlag(port, "port", curParam)
-	curParam = checkStringFlag(token, "token", curParam)
-	checkStringFlag(scope, "scope", curParam)
-
-	address := fmt.Sprintf("%s:%d", *host, *port)
+// createGatewayClient creates client of long-running commands validating
+// tokens with cube replicas listed comma-separated, tlsConf can be nil
+func createGatewayClient(cube string, tlsConf *tls.Config) *oauth2.Client {
+	return oauth2.CreateClient(oauth2.ClientConfig{
+		Endpoints:  splitEndpoints(cube),
+		TLS:        tlsConf,
+		Balancing:  oauth2.LeastOutstanding,
+		EjectAfter: 3,
+		Coalesce:   true,
+		Breaker: oauth2.BreakerConfig{
+			Window: 10 * time.Second,
+			OnStateChange: func(address string, from, to oauth2.BreakerState) {
+				log.Printf("circuit breaker of %s is %s", address, to)
+			},
+		},
+	})
+}
 
-	var err error
-	deadline := time.Now().Add(time.Second * time.Duration(*secondsToOperate))
+// withTimeout returns validator limiting time of validation by v
+func withTimeout(v oauth2.Validator, timeout time.Duration) oauth2.Validator {
+	return oauth2.ValidatorFunc(func(ctx context.Context, token, scope string) (*oauth2.ResponseOAUTH2, error) {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		return v.Validate(ctx, token, scope)
+	})
+}
 
-	fmt.Println("connecting to", address)
-	conn, err := net.DialTimeout("tcp", address, time.Second*time.Duration(*secondsToOperate))
-	if err != nil {
-		fmt.Println("failed to dial tcp", err.Error())
-		os.Exit(-1)
+func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			cmd(os.Args[2:])
+			return
+		}
 	}
-	defer conn.Close()
-	fmt.Println("connected")
-	err = conn.SetWriteDeadline(deadline)
-	if err != nil {
-		fmt.Println("failed to set write deadline", err.Error())
+	validateCmd(os.Args[1:])
+}
+
+func validateCmd(args []string) {
+	if err := fs.Parse(args); err != nil {
 		os.Exit(-1)
 	}
-	err = conn.SetReadDeadline(deadline)
-	if err != nil {
-		fmt.Println("failed to set read deadline", err.Error())
-		os.Exit(-1)
+
+	curParam := 0
+
+	addresses := splitEndpoints(*endpoints)
+	if len(addresses) == 0 {
+		curParam = checkStringFlag(host, "host", curParam)
+		curParam = checkIntFlag(port, "port", curParam)
+		addresses = []string{net.JoinHostPort(*host, strconv.Itoa(*port))}
 	}
+	curParam = checkStringFlag(token, "token", curParam)
+	checkStringFlag(scope, "scope", curParam)
 
-	fmt.Println("writing")
-	buf, err := oauth2.CreateOAUTH2Request(*token, *scope)
+	b, err := oauth2.ParseBalancing(*balancing)
 	if err != nil {
-		fmt.Println("failed to create request", err.Error())
+		fmt.Println(err.Error())
 		os.Exit(-1)
 	}
 
-	fmt.Println("reading")
-	_, err = conn.Write(buf.Bytes())
+	tlsConf, err := tlsOpts.clientConfig()
 	if err != nil {
-		fmt.Println("failed to write to connection", err.Error())
+		fmt.Println("failed to configure TLS", err.Error())
 		os.Exit(-1)
 	}
 
-	respBuf := make([]byte, buffLen)
-	r := new(oauth2.ResponseOAUTH2)
-	response := oauth2.CreateRespBuffer([]byte{})
-	go func() {
-		response.ParseOAUTH2Resp(r)
-		response.End()
-	}()
-	var readed int
-	err = nil
-	sum := 0
-CONNECT_LOOP:
-	for {
-		select {
-		case <-response.WaitChan():
-			break CONNECT_LOOP
-		default:
-			readed, err = conn.Read(respBuf)
-			sum += readed
-
-			response.Write(respBuf[:readed])
-			if err == io.EOF {
-				break CONNECT_LOOP
-			}
-			if err != nil {
-				fmt.Println("failed to read from connection", err.Error())
-				os.Exit(-1)
-			}
-		}
-	}
-
-	response.Finished()
-	<-response.WaitChan()
-
-	err = response.Error()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(*secondsToOperate))
+	defer cancel()
+
+	client := oauth2.CreateClient(oauth2.ClientConfig{
+		Endpoints: addresses,
+		Resolve:   *resolve,
+		Balancing: b,
+		TLS:       tlsConf,
+		Retry: oauth2.RetryPolicy{
+			MaxAttempts: *attempts,
+			Jitter:      0.2,
+		},
+	})
+
+	fmt.Println("validating token at", strings.Join(addresses, ", "))
+	r, err := client.Validate(ctx, *token, *scope)
 	if err != nil {
-		fmt.Println("failed to parse response", err.Error())
+		fmt.Println("failed to validate token", err.Error())
 		os.Exit(-1)
 	}
 	fmt.Println(r.String())
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Apakhov/cube/cubeapi/oauth2"
+	"github.com/Apakhov/cube/cubeapi/oauth2/oauth2http"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// headers injected into proxied requests
+const (
+	headerUserID   = "X-Cube-User-Id"
+	headerUsername = "X-Cube-Username"
+	headerClientID = "X-Cube-Client-Id"
+)
+
+// proxyConfig describes proxy config file
+type proxyConfig struct {
+	// Upstream is URL requests are forwarded to
+	Upstream string `json:"upstream" yaml:"upstream"`
+	// Routes map path prefixes to required scopes
+	Routes []proxyRoute `json:"routes" yaml:"routes"`
+}
+
+// proxyRoute requires scope from requests with path starting with Prefix.
+// Public routes are forwarded without authentication
+type proxyRoute struct {
+	Prefix string `json:"prefix" yaml:"prefix"`
+	Scope  string `json:"scope" yaml:"scope"`
+	Public bool   `json:"public" yaml:"public"`
+}
+
+// proxyCmd runs reverse proxy authenticating requests with cube
+func proxyCmd(args []string) {
+	fs := flag.NewFlagSet("cube proxy", flag.ContinueOnError)
+	addr := fs.String("addr", "localhost:8000", "address to listen on")
+	cube := fs.String("cube", "localhost:3333", "address of cube server, host:port, or comma-separated addresses of replicas")
+	config := fs.String("config", "", "proxy config file, .json or .yaml/.yml, non-empty string")
+	timeout := fs.Duration("timeout", 5*time.Second, "time before cube request deadline")
+	tlsOpts := addClientTLSFlags(fs)
+	fs.Usage = func() {
+		fmt.Println(`Usage of cube proxy:
+	cube proxy -config file [-addr host:port] [-cube host:port]
+config file has upstream URL and routes with fields prefix, scope, public.
+Request is authenticated with scope of route with the longest matching prefix,
+requests matching no route are rejected
+flags:`)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		os.Exit(-1)
+	}
+	if *config == "" {
+		fmt.Println("expected config")
+		os.Exit(-1)
+	}
+
+	conf, err := loadProxyConfig(*config)
+	if err != nil {
+		fmt.Println("failed to load config", err.Error())
+		os.Exit(-1)
+	}
+	tlsConf, err := tlsOpts.clientConfig()
+	if err != nil {
+		fmt.Println("failed to configure TLS", err.Error())
+		os.Exit(-1)
+	}
+	client := createGatewayClient(*cube, tlsConf)
+	defer client.Close()
+	handler, err := createProxyHandler(conf, withTimeout(client, *timeout))
+	if err != nil {
+		fmt.Println("failed to create proxy", err.Error())
+		os.Exit(-1)
+	}
+	server := &http.Server{Addr: *addr, Handler: handler}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-stop
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("failed to shutdown gracefully: %v", err)
+		}
+	}()
+
+	log.Printf("proxying %s to %s, validating at %s", *addr, conf.Upstream, *cube)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		fmt.Println("failed to serve", err.Error())
+		os.Exit(-1)
+	}
+}
+
+// loadProxyConfig loads proxy config from JSON or YAML file chosen by
+// extension
+func loadProxyConfig(path string) (*proxyConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	conf := &proxyConfig{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(data, conf)
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(data, conf)
+	default:
+		return nil, fmt.Errorf("unknown config format %q", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+	return conf, nil
+}
+
+// authRoute is route with handler authenticating its requests
+type authRoute struct {
+	prefix  string
+	handler http.Handler
+}
+
+// createProxyHandler creates handler authenticating requests by routes of
+// conf with v and forwarding them to upstream
+func createProxyHandler(conf *proxyConfig, v oauth2.Validator) (http.Handler, error) {
+	upstream, err := url.Parse(conf.Upstream)
+	if err != nil {
+		return nil, fmt.Errorf("bad upstream: %w", err)
+	}
+	if upstream.Scheme == "" || upstream.Host == "" {
+		return nil, fmt.Errorf("bad upstream %q", conf.Upstream)
+	}
+	proxy := httputil.NewSingleHostReverseProxy(upstream)
+	forward := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if resp, ok := oauth2http.ResponseFromContext(r.Context()); ok {
+			r.Header.Set(headerUserID, strconv.FormatInt(resp.UserID, 10))
+			r.Header.Set(headerUsername, resp.Username)
+			r.Header.Set(headerClientID, resp.CliendID)
+		}
+		proxy.ServeHTTP(w, r)
+	})
+
+	routes := make([]authRoute, 0, len(conf.Routes))
+	seen := make(map[string]bool, len(conf.Routes))
+	for i, route := range conf.Routes {
+		if !strings.HasPrefix(route.Prefix, "/") {
+			return nil, fmt.Errorf("route %d: prefix must start with /", i)
+		}
+		// "/admin/" covers "/admin" too
+		prefix := strings.TrimRight(route.Prefix, "/")
+		if prefix == "" {
+			prefix = "/"
+		}
+		if seen[prefix] {
+			return nil, fmt.Errorf("route %d: duplicate prefix", i)
+		}
+		seen[prefix] = true
+		if !route.Public && route.Scope == "" {
+			return nil, fmt.Errorf("route %d: expected scope", i)
+		}
+		r := authRoute{prefix: prefix, handler: forward}
+		if !route.Public {
+			r.handler = oauth2http.Middleware(v, route.Scope)(forward)
+		}
+		routes = append(routes, r)
+	}
+	// the longest prefix is matched first
+	sort.Slice(routes, func(i, j int) bool {
+		return len(routes[i].prefix) > len(routes[j].prefix)
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// client can't impersonate user
+		r.Header.Del(headerUserID)
+		r.Header.Del(headerUsername)
+		r.Header.Del(headerClientID)
+		// path is forwarded as is, so upstream must not resolve it to other
+		// route than the matched one
+		if !isCleanPath(r.URL.Path) {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		// hop-by-hop headers are removed by proxy after identity is set
+		if namesIdentityHeader(r.Header["Connection"]) {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		for _, route := range routes {
+			if matchPrefix(r.URL.Path, route.prefix) {
+				route.handler.ServeHTTP(w, r)
+				return
+			}
+		}
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	}), nil
+}
+
+// isCleanPath reports whether p has no dot segments, including ones with
+// path parameters like "..;", empty segments and backslashes. Trailing
+// slash is allowed
+func isCleanPath(p string) bool {
+	if !strings.HasPrefix(p, "/") || strings.Contains(p, "\\") {
+		return false
+	}
+	clean := path.Clean(p)
+	if strings.HasSuffix(p, "/") && clean != "/" {
+		clean += "/"
+	}
+	if clean != p {
+		return false
+	}
+	for _, segment := range strings.Split(p, "/") {
+		if i := strings.IndexByte(segment, ';'); i >= 0 {
+			segment = segment[:i]
+		}
+		if segment == "." || segment == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// matchPrefix reports whether p starts with prefix having no trailing slash
+// on segment boundary, "/admin" matches "/admin" and "/admin/users" but not
+// "/administrator"
+func matchPrefix(p, prefix string) bool {
+	if !strings.HasPrefix(p, prefix) {
+		return false
+	}
+	return prefix == "/" || len(p) == len(prefix) || p[len(prefix)] == '/'
+}
+
+// namesIdentityHeader reports whether Connection header values name
+// headers of user identity
+func namesIdentityHeader(connection []string) bool {
+	for _, value := range connection {
+		for _, name := range strings.Split(value, ",") {
+			switch http.CanonicalHeaderKey(strings.TrimSpace(name)) {
+			case headerUserID, headerUsername, headerClientID:
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Apakhov/cube/cubeapi/oauth2"
+	"github.com/stretchr/testify/require"
+)
+
+var proxyValidator = oauth2.ValidatorFunc(func(ctx context.Context, token, scope string) (*oauth2.ResponseOAUTH2, error) {
+	if token != "abracadabra" {
+		return oauth2.ErrorResponse(oauth2.CubeOAUTH2ErrCodeTokenNotFound), nil
+	}
+	if scope != "test" {
+		return oauth2.ErrorResponse(oauth2.CubeOAUTH2ErrCodeBadScope), nil
+	}
+	return &oauth2.ResponseOAUTH2{
+		CliendID: "cid",
+		Username: "user@mail.ru",
+		UserID:   101010,
+	}, nil
+})
+
+// startUpstream starts server echoing injected headers
+func startUpstream() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, h := range []string{headerUserID, headerUsername, headerClientID} {
+			w.Header()[h] = r.Header[h]
+		}
+		w.Write([]byte(r.URL.Path))
+	}))
+}
+
+func TestProxy(t *testing.T) {
+	upstream := startUpstream()
+	defer upstream.Close()
+	h, err := createProxyHandler(&proxyConfig{
+		Upstream: upstream.URL,
+		Routes: []proxyRoute{
+			{Prefix: "/", Scope: "test"},
+			{Prefix: "/admin", Scope: "admin"},
+			{Prefix: "/public", Public: true},
+		},
+	}, proxyValidator)
+	require.NoError(t, err)
+
+	cases := []struct {
+		path, token string
+		status      int
+		userID      string
+	}{
+		{"/api", "abracadabra", http.StatusOK, "101010"},
+		{"/api", "unknown", http.StatusUnauthorized, ""},
+		{"/api", "", http.StatusUnauthorized, ""},
+		{"/admin/users", "abracadabra", http.StatusForbidden, ""},
+		{"/public/doc", "", http.StatusOK, ""},
+		{"/public/", "", http.StatusOK, ""},
+		// prefix matches on segment boundary
+		{"/administrator", "abracadabra", http.StatusOK, "101010"},
+		{"/admin%2Fusers", "abracadabra", http.StatusForbidden, ""},
+		// paths upstream could resolve to other route
+		{"/public/../admin/users", "", http.StatusBadRequest, ""},
+		{"/public/%2e%2e/admin/users", "", http.StatusBadRequest, ""},
+		{"/public/%2E%2E%2Fadmin/users", "", http.StatusBadRequest, ""},
+		{"/public/..%2fadmin/users", "", http.StatusBadRequest, ""},
+		{"/public/..;/admin/users", "", http.StatusBadRequest, ""},
+		{"/public/.%2e;x=1/admin/users", "", http.StatusBadRequest, ""},
+		{"/public/./doc", "", http.StatusBadRequest, ""},
+		{"/public//admin", "", http.StatusBadRequest, ""},
+		{"/public/..%5cadmin/users", "", http.StatusBadRequest, ""},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", c.path, nil)
+		if c.token != "" {
+			r.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		// client supplied headers are stripped
+		r.Header.Set(headerUserID, "1")
+		r.Header.Set(headerUsername, "admin")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		require.Equal(t, c.status, w.Code, c.path)
+		if c.status != http.StatusOK {
+			continue
+		}
+		require.Equal(t, c.path, w.Body.String())
+		require.Equal(t, c.userID, w.Header().Get(headerUserID))
+		if c.userID != "" {
+			require.Equal(t, "user@mail.ru", w.Header().Get(headerUsername))
+			require.Equal(t, "cid", w.Header().Get(headerClientID))
+		} else {
+			require.Empty(t, w.Header()[headerUsername])
+		}
+	}
+}
+
+func TestProxyNoRoute(t *testing.T) {
+	h, err := createProxyHandler(&proxyConfig{
+		Upstream: "http://localhost:1",
+		Routes:   []proxyRoute{{Prefix: "/api", Scope: "test"}},
+	}, proxyValidator)
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/other", nil))
+	require.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestProxyTrailingSlashPrefix(t *testing.T) {
+	upstream := startUpstream()
+	defer upstream.Close()
+	h, err := createProxyHandler(&proxyConfig{
+		Upstream: upstream.URL,
+		Routes: []proxyRoute{
+			{Prefix: "/", Public: true},
+			{Prefix: "/admin/", Scope: "test"},
+		},
+	}, proxyValidator)
+	require.NoError(t, err)
+
+	// "/admin/" protects "/admin" too
+	for _, path := range []string{"/admin", "/admin/", "/admin/users"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		require.Equal(t, http.StatusUnauthorized, w.Code, path)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/administrator", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestProxyConnectionHeader(t *testing.T) {
+	upstream := startUpstream()
+	defer upstream.Close()
+	h, err := createProxyHandler(&proxyConfig{
+		Upstream: upstream.URL,
+		Routes:   []proxyRoute{{Prefix: "/", Scope: "test"}},
+	}, proxyValidator)
+	require.NoError(t, err)
+
+	// identity would be removed as hop-by-hop header
+	for _, connection := range []string{"x-cube-user-id", "keep-alive, X-Cube-Username"} {
+		r := httptest.NewRequest("GET", "/api", nil)
+		r.Header.Set("Authorization", "Bearer abracadabra")
+		r.Header.Set("Connection", connection)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		require.Equal(t, http.StatusBadRequest, w.Code, connection)
+	}
+
+	r := httptest.NewRequest("GET", "/api", nil)
+	r.Header.Set("Authorization", "Bearer abracadabra")
+	r.Header.Set("Connection", "keep-alive")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "101010", w.Header().Get(headerUserID))
+}
+
+func TestProxyBadConfig(t *testing.T) {
+	for _, conf := range []*proxyConfig{
+		{Upstream: "localhost:8080"},
+		{Upstream: "http://localhost", Routes: []proxyRoute{{Prefix: "api", Scope: "test"}}},
+		{Upstream: "http://localhost", Routes: []proxyRoute{{Prefix: "/api"}}},
+		{Upstream: "http://localhost", Routes: []proxyRoute{{Prefix: "/", Public: true}, {Prefix: "/", Scope: "test"}}},
+		{Upstream: "http://localhost", Routes: []proxyRoute{{Prefix: "/api", Public: true}, {Prefix: "/api/", Scope: "test"}}},
+	} {
+		_, err := createProxyHandler(conf, proxyValidator)
+		require.Error(t, err)
+	}
+}
+
+func TestLoadProxyConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "proxy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"proxy.json": `{"upstream": "http://localhost:9000", "routes": [
+			{"prefix": "/", "scope": "test"}, {"prefix": "/health", "public": true}]}`,
+		"proxy.yaml": `
+upstream: http://localhost:9000
+routes:
+  - prefix: /
+    scope: test
+  - prefix: /health
+    public: true
+`,
+	}
+	exp := &proxyConfig{
+		Upstream: "http://localhost:9000",
+		Routes: []proxyRoute{
+			{Prefix: "/", Scope: "test"},
+			{Prefix: "/health", Public: true},
+		},
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+		conf, err := loadProxyConfig(path)
+		require.NoError(t, err, name)
+		require.Equal(t, exp, conf, name)
+	}
+
+	path := filepath.Join(dir, "proxy.toml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(""), 0600))
+	_, err = loadProxyConfig(path)
+	require.Error(t, err)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Apakhov/cube/cubeapi"
+	"github.com/Apakhov/cube/cubeapi/oauth2"
+)
+
+// shutdownTimeout is time given to in-flight requests on stop
+const shutdownTimeout = 5 * time.Second
+
+// serveCmd runs mock cube OAUTH2 server answering from token file
+func serveCmd(args []string) {
+	fs := flag.NewFlagSet("cube serve", flag.ContinueOnError)
+	addr := fs.String("addr", "localhost:3333", "address to listen on")
+	tokens := fs.String("tokens", "", "token file, .json, .yaml/.yml or .csv, non-empty string")
+	reload := fs.Duration("reload", time.Second, "interval of checking token file for changes, 0 disables reloading")
+	tlsOpts := addServerTLSFlags(fs)
+	fs.Usage = func() {
+		fmt.Println(`Usage of cube serve:
+	cube serve -tokens file [-addr host:port] [-tls-cert file -tls-key file [-tls-ca file]]
+token file is list of tokens with fields
+	token, scopes, client_id, client_type, username, expires_in, user_id
+CSV file must have header naming these columns, scopes are separated by spaces
+flags:`)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		os.Exit(-1)
+	}
+	if *tokens == "" {
+		fmt.Println("expected tokens")
+		os.Exit(-1)
+	}
+
+	tlsConf, err := tlsOpts.serverConfig()
+	if err != nil {
+		fmt.Println("failed to configure TLS", err.Error())
+		os.Exit(-1)
+	}
+	table, err := loadTokenFile(*tokens)
+	if err != nil {
+		fmt.Println("failed to load tokens", err.Error())
+		os.Exit(-1)
+	}
+	l, err := net.Listen("tcp", *addr)
+	if err != nil {
+		fmt.Println("failed to listen", err.Error())
+		os.Exit(-1)
+	}
+	if tlsConf != nil {
+		l = tls.NewListener(l, tlsConf)
+	}
+	validator := &tableValidator{table: table}
+	server := cubeapi.CreateServer()
+	server.Handle(oauth2.SvcID, oauth2.CreateHandler(validator))
+	log.Printf("serving %d tokens on %s", len(table), l.Addr())
+
+	if *reload > 0 {
+		go watchTokenFile(*tokens, *reload, func(table tokenTable) {
+			validator.setTable(table)
+			log.Printf("reloaded %d tokens", len(table))
+		})
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-stop
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("failed to shutdown gracefully: %v", err)
+		}
+	}()
+	if err := server.Serve(l); err != cubeapi.ErrServerClosed {
+		fmt.Println("failed to serve", err.Error())
+		os.Exit(-1)
+	}
+}
+
+// tableValidator validates tokens by token table. Unknown tokens are
+// answered with CubeOAUTH2ErrCodeTokenNotFound, known tokens with unknown
+// scope with CubeOAUTH2ErrCodeBadScope
+type tableValidator struct {
+	lock  sync.RWMutex
+	table tokenTable
+}
+
+// Validate implements oauth2.Validator interface
+func (v *tableValidator) Validate(ctx context.Context, token, scope string) (*oauth2.ResponseOAUTH2, error) {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+	scopes, found := v.table[token]
+	if !found {
+		return oauth2.ErrorResponse(oauth2.CubeOAUTH2ErrCodeTokenNotFound), nil
+	}
+	r, found := scopes[scope]
+	if !found {
+		return oauth2.ErrorResponse(oauth2.CubeOAUTH2ErrCodeBadScope), nil
+	}
+	return &r, nil
+}
+
+func (v *tableValidator) setTable(table tokenTable) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.table = table
+}
+
+// watchTokenFile polls token file and calls update with reloaded table after
+// file changes. Broken file is reported and ignored
+func watchTokenFile(path string, interval time.Duration, update func(tokenTable)) {
+	last, _ := os.Stat(path)
+	for range time.Tick(interval) {
+		info, err := os.Stat(path)
+		if err != nil {
+			log.Printf("failed to check token file: %v", err)
+			continue
+		}
+		if last != nil && info.ModTime().Equal(last.ModTime()) && info.Size() == last.Size() {
+			continue
+		}
+		last = info
+		table, err := loadTokenFile(path)
+		if err != nil {
+			log.Printf("failed to reload tokens: %v", err)
+			continue
+		}
+		update(table)
+	}
+}
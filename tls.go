@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"flag"
+
+	"github.com/Apakhov/cube/cubeapi"
+)
+
+// tlsFlags are flags configuring TLS of cube connections
+type tlsFlags struct {
+	enable     *bool
+	ca         *string
+	cert       *string
+	key        *string
+	serverName *string
+	minVersion *string
+}
+
+// addClientTLSFlags adds flags of TLS connections to cube server. TLS is
+// enabled by -tls or any other of them
+func addClientTLSFlags(fs *flag.FlagSet) *tlsFlags {
+	return &tlsFlags{
+		enable:     fs.Bool("tls", false, "connect to cube with TLS"),
+		ca:         fs.String("tls-ca", "", "PEM bundle of CAs verifying cube server instead of system roots"),
+		cert:       fs.String("tls-cert", "", "PEM client certificate for mutual TLS"),
+		key:        fs.String("tls-key", "", "PEM key of client certificate"),
+		serverName: fs.String("tls-server-name", "", "name verifying certificate of cube server, host of address if empty"),
+		minVersion: fs.String("tls-min-version", "1.2", "minimum TLS version: 1.0, 1.1, 1.2 or 1.3"),
+	}
+}
+
+// addServerTLSFlags adds flags of TLS termination. TLS is enabled by
+// -tls-cert and -tls-key
+func addServerTLSFlags(fs *flag.FlagSet) *tlsFlags {
+	return &tlsFlags{
+		ca:         fs.String("tls-ca", "", "PEM bundle of CAs, clients are required to present certificates signed by them"),
+		cert:       fs.String("tls-cert", "", "PEM server certificate, enables TLS"),
+		key:        fs.String("tls-key", "", "PEM key of server certificate"),
+		minVersion: fs.String("tls-min-version", "1.2", "minimum TLS version: 1.0, 1.1, 1.2 or 1.3"),
+	}
+}
+
+func (f *tlsFlags) options() (cubeapi.TLSOptions, error) {
+	opts := cubeapi.TLSOptions{CAFile: *f.ca, CertFile: *f.cert, KeyFile: *f.key}
+	if f.serverName != nil {
+		opts.ServerName = *f.serverName
+	}
+	v, err := cubeapi.ParseTLSVersion(*f.minVersion)
+	if err != nil {
+		return opts, err
+	}
+	opts.MinVersion = v
+	return opts, nil
+}
+
+// clientConfig returns config of client connections, nil if TLS is disabled
+func (f *tlsFlags) clientConfig() (*tls.Config, error) {
+	opts, err := f.options()
+	if err != nil {
+		return nil, err
+	}
+	if !*f.enable && opts.CAFile == "" && opts.CertFile == "" && opts.KeyFile == "" && opts.ServerName == "" {
+		return nil, nil
+	}
+	return cubeapi.CreateClientTLSConfig(opts)
+}
+
+// serverConfig returns config of server connections, nil if TLS is disabled
+func (f *tlsFlags) serverConfig() (*tls.Config, error) {
+	opts, err := f.options()
+	if err != nil {
+		return nil, err
+	}
+	if opts.CertFile == "" && opts.KeyFile == "" {
+		if opts.CAFile != "" {
+			return nil, errors.New("expected tls-cert and tls-key with tls-ca")
+		}
+		return nil, nil
+	}
+	return cubeapi.CreateServerTLSConfig(opts)
+}
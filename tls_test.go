@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Apakhov/cube/cubeapi/oauth2/oauth2test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTLSFlags(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cube")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	certs := oauth2test.CreateCertificates()
+	for name, data := range map[string][]byte{
+		"ca.pem":         certs.CACert,
+		"server.pem":     certs.ServerCert,
+		"server-key.pem": certs.ServerKey,
+	} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), data, 0600))
+	}
+
+	parseClient := func(args ...string) (*tls.Config, error) {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		f := addClientTLSFlags(fs)
+		require.NoError(t, fs.Parse(args))
+		return f.clientConfig()
+	}
+	conf, err := parseClient()
+	require.NoError(t, err)
+	require.Nil(t, conf)
+	conf, err = parseClient("-tls")
+	require.NoError(t, err)
+	require.Nil(t, conf.RootCAs)
+	conf, err = parseClient("-tls-ca", filepath.Join(dir, "ca.pem"), "-tls-server-name", "cube.test", "-tls-min-version", "1.3")
+	require.NoError(t, err)
+	require.NotNil(t, conf.RootCAs)
+	require.Equal(t, "cube.test", conf.ServerName)
+	require.Equal(t, uint16(tls.VersionTLS13), conf.MinVersion)
+	_, err = parseClient("-tls-min-version", "2")
+	require.Error(t, err)
+
+	parseServer := func(args ...string) (*tls.Config, error) {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		f := addServerTLSFlags(fs)
+		require.NoError(t, fs.Parse(args))
+		return f.serverConfig()
+	}
+	conf, err = parseServer()
+	require.NoError(t, err)
+	require.Nil(t, conf)
+	conf, err = parseServer("-tls-cert", filepath.Join(dir, "server.pem"), "-tls-key", filepath.Join(dir, "server-key.pem"))
+	require.NoError(t, err)
+	require.Equal(t, tls.NoClientCert, conf.ClientAuth)
+	conf, err = parseServer("-tls-cert", filepath.Join(dir, "server.pem"), "-tls-key", filepath.Join(dir, "server-key.pem"),
+		"-tls-ca", filepath.Join(dir, "ca.pem"))
+	require.NoError(t, err)
+	require.Equal(t, tls.RequireAndVerifyClientCert, conf.ClientAuth)
+	_, err = parseServer("-tls-ca", filepath.Join(dir, "ca.pem"))
+	require.Error(t, err)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/Apakhov/cube/cubeapi/oauth2"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// tokenEntry describes token of token file
+type tokenEntry struct {
+	Token      string   `json:"token" yaml:"token"`
+	Scopes     []string `json:"scopes" yaml:"scopes"`
+	ClientID   string   `json:"client_id" yaml:"client_id"`
+	ClientType int32    `json:"client_type" yaml:"client_type"`
+	Username   string   `json:"username" yaml:"username"`
+	ExpiresIn  int32    `json:"expires_in" yaml:"expires_in"`
+	UserID     int64    `json:"user_id" yaml:"user_id"`
+}
+
+// tokenTable maps token to scope to response
+type tokenTable map[string]map[string]oauth2.ResponseOAUTH2
+
+// loadTokenFile loads token table from JSON, YAML or CSV file chosen by
+// extension
+func loadTokenFile(path string) (tokenTable, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token file: %w", err)
+	}
+
+	var entries []tokenEntry
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = unmarshalJSONStrict(data, &entries)
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(data, &entries)
+	case ".csv":
+		entries, err = parseTokenCSV(strings.NewReader(string(data)))
+	default:
+		return nil, fmt.Errorf("unknown token file format %q", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse token file: %w", err)
+	}
+	return buildTokenTable(entries)
+}
+
+// unmarshalJSONStrict unmarshals data into v like json.Unmarshal, but
+// rejecting unknown fields as yaml.UnmarshalStrict does
+func unmarshalJSONStrict(data []byte, v interface{}) error {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.DisallowUnknownFields()
+	if err := d.Decode(v); err != nil {
+		return err
+	}
+	if _, err := d.Token(); err != io.EOF {
+		return errors.New("unexpected data after JSON value")
+	}
+	return nil
+}
+
+func buildTokenTable(entries []tokenEntry) (tokenTable, error) {
+	table := make(tokenTable, len(entries))
+	for i, e := range entries {
+		if e.Token == "" {
+			return nil, fmt.Errorf("token %d: empty token", i)
+		}
+		if _, ok := table[e.Token]; ok {
+			return nil, fmt.Errorf("token %d: duplicate token", i)
+		}
+		scopes := make(map[string]oauth2.ResponseOAUTH2, len(e.Scopes))
+		for _, scope := range e.Scopes {
+			scopes[scope] = oauth2.ResponseOAUTH2{
+				ReturnCode: oauth2.CubeOAUTH2ErrCodeOK,
+				CliendID:   e.ClientID,
+				ClientType: e.ClientType,
+				Username:   e.Username,
+				ExpiresIn:  e.ExpiresIn,
+				UserID:     e.UserID,
+			}
+		}
+		table[e.Token] = scopes
+	}
+	return table, nil
+}
+
+// csvColumns are columns of CSV token file, scopes are separated by spaces
+var csvColumns = []string{"token", "scopes", "client_id", "client_type", "username", "expires_in", "user_id"}
+
+// parseTokenCSV parses CSV with header naming csvColumns in any order
+func parseTokenCSV(r io.Reader) ([]tokenEntry, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	pos := make(map[string]int, len(csvColumns))
+	for i, name := range records[0] {
+		pos[strings.TrimSpace(name)] = i
+	}
+	for _, name := range csvColumns {
+		if _, ok := pos[name]; !ok {
+			return nil, fmt.Errorf("missing column %q", name)
+		}
+	}
+
+	entries := make([]tokenEntry, 0, len(records)-1)
+	for line, rec := range records[1:] {
+		e, err := parseTokenRecord(rec, pos)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line+2, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func parseTokenRecord(rec []string, pos map[string]int) (e tokenEntry, err error) {
+	field := func(name string) string {
+		return strings.TrimSpace(rec[pos[name]])
+	}
+	e.Token = field("token")
+	e.Scopes = strings.Fields(field("scopes"))
+	e.ClientID = field("client_id")
+	e.Username = field("username")
+
+	clientType, err := strconv.ParseInt(field("client_type"), 10, 32)
+	if err != nil {
+		return e, fmt.Errorf("bad client_type: %w", err)
+	}
+	expiresIn, err := strconv.ParseInt(field("expires_in"), 10, 32)
+	if err != nil {
+		return e, fmt.Errorf("bad expires_in: %w", err)
+	}
+	e.UserID, err = strconv.ParseInt(field("user_id"), 10, 64)
+	if err != nil {
+		return e, fmt.Errorf("bad user_id: %w", err)
+	}
+	e.ClientType = int32(clientType)
+	e.ExpiresIn = int32(expiresIn)
+	return e, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Apakhov/cube/cubeapi/oauth2"
+	"github.com/stretchr/testify/require"
+)
+
+var tokenFiles = map[string]string{
+	"tokens.json": `[
+	{"token": "abracadabra", "scopes": ["test", "admin"], "client_id": "cid", "client_type": 2002,
+	 "username": "user@mail.ru", "expires_in": 3600, "user_id": 101010},
+	{"token": "noscope", "scopes": [], "client_id": "cid2", "client_type": 1,
+	 "username": "other", "expires_in": 60, "user_id": 7}
+]`,
+	"tokens.yaml": `
+- token: abracadabra
+  scopes: [test, admin]
+  client_id: cid
+  client_type: 2002
+  username: user@mail.ru
+  expires_in: 3600
+  user_id: 101010
+- token: noscope
+  scopes: []
+  client_id: cid2
+  client_type: 1
+  username: other
+  expires_in: 60
+  user_id: 7
+`,
+	"tokens.csv": `token,scopes,client_id,client_type,username,expires_in,user_id
+abracadabra,test admin,cid,2002,user@mail.ru,3600,101010
+noscope,,cid2,1,other,60,7
+`,
+}
+
+func writeTokenFile(t *testing.T, name, content string) (path string, cleanup func()) {
+	dir, err := ioutil.TempDir("", "cube")
+	require.NoError(t, err)
+	path = filepath.Join(dir, name)
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestLoadTokenFile(t *testing.T) {
+	resp := oauth2.ResponseOAUTH2{
+		ReturnCode: oauth2.CubeOAUTH2ErrCodeOK,
+		CliendID:   "cid",
+		ClientType: 2002,
+		Username:   "user@mail.ru",
+		ExpiresIn:  3600,
+		UserID:     101010,
+	}
+	exp := tokenTable{
+		"abracadabra": {"test": resp, "admin": resp},
+		"noscope":     {},
+	}
+	for name, content := range tokenFiles {
+		path, cleanup := writeTokenFile(t, name, content)
+		table, err := loadTokenFile(path)
+		cleanup()
+		require.NoError(t, err, name)
+		require.Equal(t, exp, table, name)
+	}
+}
+
+func TestLoadTokenFileErr(t *testing.T) {
+	files := map[string]string{
+		"tokens.txt":  ``,
+		"tokens.json": `{"token": "abracadabra"}`,
+		"tokens.yaml": `- token: abracadabra
+  unknown: field`,
+		"unknown.json":  `[{"token": "abracadabra", "scope": ["test"]}]`,
+		"trailing.json": `[{"token": "abracadabra"}] []`,
+		"tokens.csv": `token,scopes,client_id,client_type,username,expires_in
+abracadabra,test,cid,2002,user@mail.ru,3600`,
+		"bad_user_id.csv": `token,scopes,client_id,client_type,username,expires_in,user_id
+abracadabra,test,cid,2002,user@mail.ru,3600,user`,
+		"duplicate.json": `[{"token": "abracadabra"}, {"token": "abracadabra"}]`,
+		"empty.json":     `[{"token": ""}]`,
+	}
+	for name, content := range files {
+		path, cleanup := writeTokenFile(t, name, content)
+		_, err := loadTokenFile(path)
+		cleanup()
+		require.Error(t, err, name)
+	}
+}